@@ -0,0 +1,213 @@
+package toolkit
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chuangxin1/httprouter"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+)
+
+const (
+	// AccessLogJSON JSON access log lines
+	AccessLogJSON = `json`
+	// AccessLogLogfmt logfmt access log lines
+	AccessLogLogfmt = `logfmt`
+
+	redactedValue = `REDACTED`
+
+	contextKeyAccessRecord contextStringKey = `access_record`
+)
+
+// default redacted query params
+var accessLogRedact = []string{
+	VarUserAuthorization, "token", "password", "secret", "api_key"}
+
+// AccessLogConfig access log config
+type AccessLogConfig struct {
+	// Format AccessLogJSON or AccessLogLogfmt, default logfmt
+	Format string
+	// Output writer of the log lines, default os.Stdout
+	Output io.Writer
+	// SampleRate ratio of logged successful requests, default 1. Failed
+	// requests are always logged.
+	SampleRate float64
+	// Redact query params whose values are hidden, default access_token,
+	// token, password, secret and api_key
+	Redact []string
+}
+
+// accessRecord values of the request only known to the endpoint
+type accessRecord struct {
+	lock        sync.Mutex
+	route       string
+	replyStatus string
+	userID      string
+	requestID   string
+	// response and err of the endpoint, classified by LoadShedMiddleware
+	response interface{}
+	err      error
+}
+
+// statusWriter records the status and size of the response
+type statusWriter struct {
+	wrapWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack implements http.Hijacker, the status of a hijacked connection is
+// 101
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.wrapWriter.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// AccessLogMiddleware log every request with its route, status, ReplyData
+// status, size, latency, user id and request id
+func AccessLogMiddleware(cfg AccessLogConfig) HTTPMiddleware {
+	out := cfg.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	var logger log.Logger
+	if cfg.Format == AccessLogJSON {
+		logger = log.NewJSONLogger(log.NewSyncWriter(out))
+	} else {
+		logger = log.NewLogfmtLogger(log.NewSyncWriter(out))
+	}
+	sample := cfg.SampleRate
+	if sample <= 0 {
+		sample = 1
+	}
+	redact := cfg.Redact
+	if redact == nil {
+		redact = accessLogRedact
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+			sw := &statusWriter{wrapWriter: wrapWriter{w}}
+			// shared with LoadShedMiddleware
+			rec, ok := r.Context().Value(contextKeyAccessRecord).(*accessRecord)
+			if !ok {
+				rec = &accessRecord{}
+				r = r.WithContext(context.WithValue(
+					r.Context(), contextKeyAccessRecord, rec))
+			}
+
+			next.ServeHTTP(sw, r)
+
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			rec.lock.Lock()
+			defer rec.lock.Unlock()
+			failed := sw.status >= http.StatusInternalServerError ||
+				(rec.replyStatus != "" && rec.replyStatus != strconv.Itoa(ErrOk))
+			if !failed && sample < 1 && rand.Float64() >= sample {
+				return
+			}
+			route := rec.route
+			if route == "" {
+				route = r.URL.Path
+			}
+			id := rec.requestID
+			if id == "" {
+				id = w.Header().Get(HTTPHeaderRequestID)
+			}
+			logger.Log(
+				"ts", begin.Format(time.RFC3339Nano),
+				"method", r.Method,
+				"route", route,
+				"uri", redactURI(r.URL, redact),
+				"status", sw.status,
+				"reply_status", rec.replyStatus,
+				"bytes", sw.bytes,
+				"latency", time.Since(begin).Seconds(),
+				"user_id", rec.userID,
+				"request_id", id,
+				"remote_addr", r.RemoteAddr,
+				"client_ip", ClientIP(r))
+		})
+	}
+}
+
+// redactURI hides the values of sensitive query params
+func redactURI(u *url.URL, redact []string) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	values := u.Query()
+	for k := range values {
+		for _, name := range redact {
+			if strings.EqualFold(k, name) {
+				values[k] = []string{redactedValue}
+			}
+		}
+	}
+	return u.Path + "?" + values.Encode()
+}
+
+// AccessLogRecordMiddleware record the route, ReplyData status and request
+// id for AccessLogMiddleware
+func AccessLogRecordMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (response interface{}, err error) {
+			rec, ok := ctx.Value(contextKeyAccessRecord).(*accessRecord)
+			if !ok {
+				return next(ctx, request)
+			}
+			response, err = next(ctx, request)
+			rec.lock.Lock()
+			rec.route = httprouter.ContextRoutePath(ctx)
+			rec.replyStatus = replyStatus(response, err)
+			rec.response, rec.err = response, err
+			rec.requestID = ContextRequestID(ctx)
+			rec.lock.Unlock()
+			return
+		}
+	}
+}
+
+// setAccessLogUser record the signed in user for AccessLogMiddleware
+func setAccessLogUser(ctx context.Context, id int) {
+	if rec, ok := ctx.Value(contextKeyAccessRecord).(*accessRecord); ok {
+		rec.lock.Lock()
+		rec.userID = strconv.Itoa(id)
+		rec.lock.Unlock()
+	}
+}
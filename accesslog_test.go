@@ -0,0 +1,82 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+// testReplyHandler endpoint handler replying status
+func testReplyHandler(status int) http.Handler {
+	return NewHTTPTansportServer(false,
+		func(context.Context, interface{}) (interface{}, error) {
+			return NewReplyData(status), nil
+		},
+		func(context.Context, *http.Request) (interface{}, error) {
+			return struct{}{}, nil
+		},
+		HTTPWriteCtxJSON, log.NewNopLogger())
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var out bytes.Buffer
+	h := AccessLogMiddleware(AccessLogConfig{
+		Format: AccessLogJSON, Output: &out})(
+		testReplyHandler(ErrDataNotFound))
+	r := httptest.NewRequest("GET",
+		"/users?access_token=a&Password=b&page=2", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q: %v", out.String(), err)
+	}
+	uri := "/users?Password=REDACTED&access_token=REDACTED&page=2"
+	for k, want := range map[string]interface{}{
+		"method":       "GET",
+		"uri":          uri,
+		"status":       float64(http.StatusOK),
+		"reply_status": "1007",
+		"bytes":        float64(w.Body.Len()),
+		"request_id":   w.Header().Get(HTTPHeaderRequestID),
+	} {
+		if line[k] != want {
+			t.Errorf("%s = %v, want %v", k, line[k], want)
+		}
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	var out bytes.Buffer
+	cfg := AccessLogConfig{Output: &out, SampleRate: 1e-9}
+	for status, logged := range map[int]bool{
+		ErrOk:           false,
+		ErrDataNotFound: true,
+	} {
+		out.Reset()
+		h := AccessLogMiddleware(cfg)(testReplyHandler(status))
+		h.ServeHTTP(
+			httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if (out.Len() > 0) != logged {
+			t.Errorf("status %d logged %q, want %v", status, out.String(),
+				logged)
+		}
+	}
+	// failed http statuses are logged too
+	out.Reset()
+	h := AccessLogMiddleware(cfg)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(out.String(), "status=502") {
+		t.Errorf("log %q, want the 502 logged", out.String())
+	}
+}
@@ -84,7 +84,8 @@ func ParseAccessToken(accessToken string) (AccessToken, error) {
 			return accessTokenKey, nil
 		})
 
-	if token.Valid {
+	// a malformed token is not parsed at all
+	if token != nil && token.Valid {
 		claims := token.Claims.(jwt.MapClaims)
 		if id, ok := claims["id"]; ok {
 			tok.ID = id.(string)
@@ -0,0 +1,520 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// load balancing strategies
+const (
+	// BalanceRoundRobin each instance in turn
+	BalanceRoundRobin = iota
+	// BalanceRandom a random instance
+	BalanceRandom
+	// BalanceLeastOutstanding the least busy of two random instances
+	BalanceLeastOutstanding
+	// BalanceWeighted a random instance by the weights of ClientWeights
+	BalanceWeighted
+	// BalanceConsistentHash the instance owning the key of ClientHashKey
+	BalanceConsistentHash
+)
+
+// virtual nodes of an instance on the consistent hash ring
+const hashReplicas = 100
+
+// ErrNoInstances no instance of the service is available
+var ErrNoInstances = errors.New("no instances available")
+
+// HashKeyFunc returns the consistent hash key of the request
+type HashKeyFunc func(ctx context.Context, request interface{}) string
+
+// ClientBalance sets the load balancing strategy of FactoryLoadBalancer and
+// ClientLoadBalancer, default BalanceRoundRobin
+func ClientBalance(strategy int) ClientOption {
+	return func(o *clientOptions) { o.balance = strategy }
+}
+
+// ClientHashKey sets the key of BalanceConsistentHash, requests without key
+// go to a random instance
+func ClientHashKey(key HashKeyFunc) ClientOption {
+	return func(o *clientOptions) { o.hashKey = key }
+}
+
+// ClientWeights sets the weights of BalanceWeighted by instance, host:port
+// for FactoryLoadBalancer and the endpoint index for ClientLoadBalancer.
+// Instances of weight 0 get no requests, default 1.
+func ClientWeights(weight func(instance string) int) ClientOption {
+	return func(o *clientOptions) { o.weight = weight }
+}
+
+// lbInstance endpoint of an instance counting its outstanding requests
+// and observed by the outlier detector
+type lbInstance struct {
+	name         string
+	endpoint     endpoint.Endpoint
+	closer       io.Closer
+	outstanding  int64
+	ejectedUntil int64
+	stats        outlierStats
+}
+
+type ringPoint struct {
+	hash     uint32
+	instance int
+}
+
+// lbSnapshot immutable instances of a service
+type lbSnapshot struct {
+	instances []*lbInstance
+	ringOnce  sync.Once
+	ring      []ringPoint
+}
+
+// hashRing returns the consistent hash ring, built on first use
+func (s *lbSnapshot) hashRing() []ringPoint {
+	s.ringOnce.Do(func() {
+		s.ring = make([]ringPoint, 0, len(s.instances)*hashReplicas)
+		for i, inst := range s.instances {
+			for r := 0; r < hashReplicas; r++ {
+				s.ring = append(s.ring, ringPoint{
+					hash: crc32.ChecksumIEEE(
+						[]byte(inst.name + "#" + strconv.Itoa(r))),
+					instance: i})
+			}
+		}
+		sort.Slice(s.ring, func(i, j int) bool {
+			return s.ring[i].hash < s.ring[j].hash
+		})
+	})
+	return s.ring
+}
+
+// instanceSet instances of a service, it is a sd.Endpointer
+type instanceSet struct {
+	lock     sync.RWMutex
+	snapshot *lbSnapshot
+	outlier  *outlierDetector
+
+	// instancer and events of sdInstances
+	instancer sd.Instancer
+	events    chan sd.Event
+	closed    bool
+}
+
+func (s *instanceSet) newInstance(
+	name string,
+	e endpoint.Endpoint,
+	closer io.Closer) *lbInstance {
+	i := &lbInstance{name: name, closer: closer}
+	i.endpoint = func(
+		ctx context.Context,
+		request interface{}) (interface{}, error) {
+		atomic.AddInt64(&i.outstanding, 1)
+		defer atomic.AddInt64(&i.outstanding, -1)
+		if s.outlier == nil {
+			return e(ctx, request)
+		}
+		begin := time.Now()
+		response, err := e(ctx, request)
+		s.outlier.observe(ctx, s, i, response, err, time.Since(begin))
+		return response, err
+	}
+	return i
+}
+
+func (s *instanceSet) current() *lbSnapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.snapshot
+}
+
+// Endpoints implements sd.Endpointer
+func (s *instanceSet) Endpoints() ([]endpoint.Endpoint, error) {
+	snap := s.current()
+	endpoints := make([]endpoint.Endpoint, len(snap.instances))
+	for i, inst := range snap.instances {
+		endpoints[i] = inst.endpoint
+	}
+	return endpoints, nil
+}
+
+// fixedInstances instances of fixed endpoints named by their index
+func fixedInstances(
+	endpoints []endpoint.Endpoint,
+	outlier *outlierDetector) *instanceSet {
+	s := &instanceSet{outlier: outlier}
+	snap := &lbSnapshot{instances: make([]*lbInstance, len(endpoints))}
+	for i, e := range endpoints {
+		snap.instances[i] = s.newInstance(strconv.Itoa(i), e, nil)
+	}
+	s.snapshot = snap
+	return s
+}
+
+// sdInstances instances of instancer, the endpoints are created by factory
+// and closed when their instance goes away
+func sdInstances(
+	instancer sd.Instancer,
+	factory sd.Factory,
+	outlier *outlierDetector,
+	logger log.Logger) *instanceSet {
+	s := &instanceSet{
+		snapshot:  &lbSnapshot{},
+		outlier:   outlier,
+		instancer: instancer,
+		events:    make(chan sd.Event)}
+	// the instancer sends the current instances while registering
+	events := s.events
+	SafeGo(context.Background(), func(context.Context) {
+		for event := range events {
+			s.update(event, factory, logger)
+		}
+	})
+	instancer.Register(events)
+	return s
+}
+
+// Close stops following the instancer and closes the endpoints of the
+// instances
+func (s *instanceSet) Close() error {
+	if s.instancer == nil {
+		return nil
+	}
+	// deregister first, the instancer may be sending an event
+	s.instancer.Deregister(s.events)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.events)
+	for _, inst := range s.snapshot.instances {
+		if inst.closer != nil {
+			inst.closer.Close()
+		}
+	}
+	s.snapshot = &lbSnapshot{}
+	return nil
+}
+
+func (s *instanceSet) update(
+	event sd.Event,
+	factory sd.Factory,
+	logger log.Logger) {
+	if event.Err != nil {
+		// keep the last known instances
+		logger.Log("err", event.Err)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	existing := map[string]*lbInstance{}
+	for _, inst := range s.snapshot.instances {
+		existing[inst.name] = inst
+	}
+	names := append([]string{}, event.Instances...)
+	sort.Strings(names)
+	snap := &lbSnapshot{instances: make([]*lbInstance, 0, len(names))}
+	for _, name := range names {
+		if inst, ok := existing[name]; ok {
+			snap.instances = append(snap.instances, inst)
+			delete(existing, name)
+			continue
+		}
+		e, closer, err := factory(name)
+		if err != nil {
+			logger.Log("instance", name, "err", err)
+			continue
+		}
+		snap.instances = append(
+			snap.instances, s.newInstance(name, e, closer))
+	}
+	for _, inst := range existing {
+		if inst.closer != nil {
+			inst.closer.Close()
+		}
+	}
+	s.snapshot = snap
+}
+
+// picker selects the instance of an attempt, the retries and hedges of a
+// request have increasing attempts
+type picker interface {
+	pick(
+		ctx context.Context,
+		request interface{},
+		attempt int) (*lbInstance, error)
+}
+
+// newPicker new picker of the strategy of o over the instances of s
+func newPicker(s *instanceSet, o *clientOptions) picker {
+	switch o.balance {
+	case BalanceRandom:
+		return randomPicker{s}
+	case BalanceLeastOutstanding:
+		return p2cPicker{s}
+	case BalanceWeighted:
+		weight := o.weight
+		if weight == nil {
+			weight = func(string) int { return 1 }
+		}
+		return weightedPicker{s, weight}
+	case BalanceConsistentHash:
+		return hashPicker{s, o.hashKey}
+	}
+	return &roundRobinPicker{s: s}
+}
+
+// liveInstances returns the instances of s not ejected, all of them when
+// they are all ejected, ErrNoInstances when empty
+func liveInstances(s *instanceSet) ([]*lbInstance, *lbSnapshot, error) {
+	snap := s.current()
+	if len(snap.instances) == 0 {
+		return nil, nil, ErrNoInstances
+	}
+	if s.outlier == nil {
+		return snap.instances, snap, nil
+	}
+	now := time.Now().UnixNano()
+	list := make([]*lbInstance, 0, len(snap.instances))
+	for _, inst := range snap.instances {
+		if !inst.ejected(now) {
+			list = append(list, inst)
+		}
+	}
+	if len(list) == 0 {
+		return snap.instances, snap, nil
+	}
+	return list, snap, nil
+}
+
+type roundRobinPicker struct {
+	s       *instanceSet
+	counter uint64
+}
+
+func (p *roundRobinPicker) pick(
+	_ context.Context,
+	_ interface{},
+	_ int) (*lbInstance, error) {
+	list, _, err := liveInstances(p.s)
+	if err != nil {
+		return nil, err
+	}
+	n := atomic.AddUint64(&p.counter, 1) - 1
+	return list[n%uint64(len(list))], nil
+}
+
+type randomPicker struct {
+	s *instanceSet
+}
+
+func (p randomPicker) pick(
+	_ context.Context,
+	_ interface{},
+	_ int) (*lbInstance, error) {
+	list, _, err := liveInstances(p.s)
+	if err != nil {
+		return nil, err
+	}
+	return list[rand.Intn(len(list))], nil
+}
+
+// p2cPicker power of two choices, the instance with less outstanding
+// requests of two random ones
+type p2cPicker struct {
+	s *instanceSet
+}
+
+func (p p2cPicker) pick(
+	_ context.Context,
+	_ interface{},
+	_ int) (*lbInstance, error) {
+	list, _, err := liveInstances(p.s)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	a := rand.Intn(len(list))
+	b := rand.Intn(len(list) - 1)
+	if b >= a {
+		b++
+	}
+	if atomic.LoadInt64(&list[b].outstanding) <
+		atomic.LoadInt64(&list[a].outstanding) {
+		a = b
+	}
+	return list[a], nil
+}
+
+type weightedPicker struct {
+	s      *instanceSet
+	weight func(instance string) int
+}
+
+func (p weightedPicker) pick(
+	_ context.Context,
+	_ interface{},
+	_ int) (*lbInstance, error) {
+	list, _, err := liveInstances(p.s)
+	if err != nil {
+		return nil, err
+	}
+	weights := make([]int, len(list))
+	total := 0
+	for i, inst := range list {
+		if w := p.weight(inst.name); w > 0 {
+			weights[i] = w
+			total += w
+		}
+	}
+	if total == 0 {
+		return list[rand.Intn(len(list))], nil
+	}
+	n := rand.Intn(total)
+	for i, w := range weights {
+		if n < w {
+			return list[i], nil
+		}
+		n -= w
+	}
+	return list[len(list)-1], nil
+}
+
+// hashPicker consistent hashing, the retries go to the next instances of
+// the ring
+type hashPicker struct {
+	s   *instanceSet
+	key HashKeyFunc
+}
+
+func (p hashPicker) pick(
+	ctx context.Context,
+	request interface{},
+	attempt int) (*lbInstance, error) {
+	list, snap, err := liveInstances(p.s)
+	if err != nil {
+		return nil, err
+	}
+	key := ""
+	if p.key != nil {
+		key = p.key(ctx, request)
+	}
+	if key == "" {
+		return list[rand.Intn(len(list))], nil
+	}
+	ring := snap.hashRing()
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	// the ring holds all the instances, the ejected ones are skipped
+	live := make(map[*lbInstance]bool, len(list))
+	for _, inst := range list {
+		live[inst] = true
+	}
+	attempt %= len(list)
+	seen := map[int]bool{}
+	for n := 0; n < len(ring); n++ {
+		point := ring[(i+n)%len(ring)]
+		inst := snap.instances[point.instance]
+		if seen[point.instance] || !live[inst] {
+			continue
+		}
+		if len(seen) == attempt {
+			return inst, nil
+		}
+		seen[point.instance] = true
+	}
+	return list[0], nil
+}
+
+// ConsulWeights returns the weights of the instances of the service name
+// for ClientWeights, from the weight meta or the weight=N tag of the
+// instances. The weights are refreshed every 30 seconds.
+func ConsulWeights(
+	options ServiceOptions,
+	name string) (func(instance string) int, error) {
+	config := consulapi.DefaultConfig()
+	config.Address = options.Address
+	config.Scheme = options.Scheme
+	config.Datacenter = options.Datacenter
+	config.WaitTime = options.WaitTime
+	config.Token = options.Token
+	client, err := consulapi.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lock    sync.Mutex
+		weights map[string]int
+		expires time.Time
+	)
+	refresh := func(context.Context) {
+		entries, _, err := client.Health().Service(name, "", true, nil)
+		if err != nil {
+			return
+		}
+		w := map[string]int{}
+		for _, entry := range entries {
+			addr := entry.Service.Address
+			if addr == "" {
+				addr = entry.Node.Address
+			}
+			w[addr+":"+strconv.Itoa(entry.Service.Port)] =
+				serviceWeight(entry.Service)
+		}
+		lock.Lock()
+		weights = w
+		lock.Unlock()
+	}
+	refresh(context.Background())
+	expires = time.Now().Add(30 * time.Second)
+	return func(instance string) int {
+		lock.Lock()
+		defer lock.Unlock()
+		if time.Now().After(expires) {
+			// the picks keep the last weights until the refresh is done
+			expires = time.Now().Add(30 * time.Second)
+			SafeGo(context.Background(), refresh)
+		}
+		if w, ok := weights[instance]; ok {
+			return w
+		}
+		return 1
+	}, nil
+}
+
+// serviceWeight weight of a service from its meta or tags, default 1
+func serviceWeight(s *consulapi.AgentService) int {
+	if w, err := strconv.Atoi(s.Meta["weight"]); err == nil && w >= 0 {
+		return w
+	}
+	for _, tag := range s.Tags {
+		if strings.HasPrefix(tag, "weight=") {
+			if w, err := strconv.Atoi(tag[7:]); err == nil && w >= 0 {
+				return w
+			}
+		}
+	}
+	return 1
+}
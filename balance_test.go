@@ -0,0 +1,135 @@
+package toolkit
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+)
+
+// testInstancer sends the instances synchronously like the go-kit
+// instancers, while registering and on every change
+type testInstancer struct {
+	lock      sync.Mutex
+	instances []string
+	channels  map[chan<- sd.Event]bool
+}
+
+func (i *testInstancer) Register(ch chan<- sd.Event) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.channels == nil {
+		i.channels = map[chan<- sd.Event]bool{}
+	}
+	i.channels[ch] = true
+	ch <- sd.Event{Instances: i.instances}
+}
+
+func (i *testInstancer) Deregister(ch chan<- sd.Event) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	delete(i.channels, ch)
+}
+
+func (i *testInstancer) Stop() {}
+
+func (i *testInstancer) set(instances ...string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.instances = instances
+	for ch := range i.channels {
+		ch <- sd.Event{Instances: instances}
+	}
+}
+
+func (i *testInstancer) registered() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return len(i.channels)
+}
+
+type testCloser struct {
+	lock   sync.Mutex
+	closed map[string]int
+}
+
+func (c *testCloser) factory(instance string) (
+	endpoint.Endpoint, io.Closer, error) {
+	return endpoint.Nop, testCloserFunc(func() error {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.closed[instance]++
+		return nil
+	}), nil
+}
+
+func (c *testCloser) count(instance string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed[instance]
+}
+
+type testCloserFunc func() error
+
+func (f testCloserFunc) Close() error { return f() }
+
+// waitInstances waits for the instances of s to be n
+func waitInstances(t *testing.T, s *instanceSet, n int) {
+	deadline := time.Now().Add(time.Second)
+	for len(s.current().instances) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d instances, want %d",
+				len(s.current().instances), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSDInstances(t *testing.T) {
+	instancer := &testInstancer{instances: []string{"a:1", "b:1"}}
+	closer := &testCloser{closed: map[string]int{}}
+	done := make(chan *instanceSet)
+	go func() {
+		done <- sdInstances(
+			instancer, closer.factory, nil, log.NewNopLogger())
+	}()
+	var s *instanceSet
+	select {
+	case s = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sdInstances is blocked registering")
+	}
+	waitInstances(t, s, 2)
+	kept := s.current().instances[0]
+
+	instancer.set("a:1")
+	waitInstances(t, s, 1)
+	if s.current().instances[0] != kept {
+		t.Error("the kept instance was created again")
+	}
+	if closer.count("b:1") != 1 || closer.count("a:1") != 0 {
+		t.Errorf("closed %v, want b:1", closer.closed)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if closer.count("a:1") != 1 {
+		t.Errorf("closed %v, want a:1 once", closer.closed)
+	}
+	if instancer.registered() != 0 {
+		t.Error("still registered after Close")
+	}
+	if endpoints, _ := s.Endpoints(); len(endpoints) != 0 {
+		t.Errorf("%d endpoints after Close", len(endpoints))
+	}
+	// a second close does nothing
+	s.Close()
+	if closer.count("a:1") != 1 {
+		t.Errorf("closed %v, want a:1 once", closer.closed)
+	}
+}
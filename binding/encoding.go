@@ -0,0 +1,76 @@
+package binding
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MaxDecompressedSize largest decoded size of a gzip request body, a zero
+// or negative size is unlimited
+var MaxDecompressedSize int64 = 32 << 20
+
+// ErrDecompressedTooLarge the decoded request body exceeds
+// MaxDecompressedSize
+var ErrDecompressedTooLarge = errors.New("decompressed body too large")
+
+// gzipBody closes the gzip reader and the request body, reading at most
+// left more bytes
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+	left int64
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return b.Reader.Read(p)
+	}
+	if b.left == 0 {
+		// a body of exactly the limit ends here
+		var one [1]byte
+		if n, _ := b.Reader.Read(one[:]); n == 0 {
+			return 0, io.EOF
+		}
+		return 0, ErrDecompressedTooLarge
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.Reader.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// decodeBody replaces a gzip encoded request body by its decoded content
+func decodeBody(req *http.Request) error {
+	switch strings.ToLower(strings.TrimSpace(
+		req.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+	default:
+		return nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	r, err := gzip.NewReader(req.Body)
+	if err != nil {
+		return err
+	}
+	left := MaxDecompressedSize
+	if left <= 0 {
+		left = -1
+	}
+	req.Body = &gzipBody{Reader: r, body: req.Body, left: left}
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	return nil
+}
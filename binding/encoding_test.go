@@ -0,0 +1,47 @@
+package binding
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testGzipBody(t *testing.T, body string) *bytes.Buffer {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return &b
+}
+
+func TestBindGzipBody(t *testing.T) {
+	defer func(size int64) { MaxDecompressedSize = size }(MaxDecompressedSize)
+	body := `{"name":"` + strings.Repeat("a", 100) + `"}`
+	for size, fails := range map[int64]bool{
+		0:                    false,
+		int64(len(body)):     false,
+		int64(len(body)) - 1: true,
+	} {
+		MaxDecompressedSize = size
+		req := httptest.NewRequest("POST", "/", testGzipBody(t, body))
+		req.Header.Set("Content-Type", MIMEJSON)
+		req.Header.Set("Content-Encoding", "gzip")
+		var obj struct {
+			Name string `json:"name"`
+		}
+		err := JSON.Bind(req, &obj)
+		if fails {
+			if err != ErrDecompressedTooLarge {
+				t.Errorf("limit %d: error %v, want too large", size, err)
+			}
+			continue
+		}
+		if err != nil || len(obj.Name) != 100 {
+			t.Errorf("limit %d: name %q, error %v", size, obj.Name, err)
+		}
+	}
+}
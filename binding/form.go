@@ -19,6 +19,9 @@ func (formBinding) Name() string {
 }
 
 func (formBinding) Bind(req *http.Request, obj interface{}) error {
+	if err := decodeBody(req); err != nil {
+		return err
+	}
 	if err := req.ParseForm(); err != nil {
 		return err
 	}
@@ -34,6 +37,9 @@ func (formPostBinding) Name() string {
 }
 
 func (formPostBinding) Bind(req *http.Request, obj interface{}) error {
+	if err := decodeBody(req); err != nil {
+		return err
+	}
 	if err := req.ParseForm(); err != nil {
 		return err
 	}
@@ -48,6 +54,9 @@ func (formMultipartBinding) Name() string {
 }
 
 func (formMultipartBinding) Bind(req *http.Request, obj interface{}) error {
+	if err := decodeBody(req); err != nil {
+		return err
+	}
 	if err := req.ParseMultipartForm(defaultMemory); err != nil {
 		return err
 	}
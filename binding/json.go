@@ -21,6 +21,9 @@ func (jsonBinding) Name() string {
 }
 
 func (jsonBinding) Bind(req *http.Request, obj interface{}) error {
+	if err := decodeBody(req); err != nil {
+		return err
+	}
 	decoder := json.NewDecoder(req.Body)
 	if EnableDecoderUseNumber {
 		decoder.UseNumber()
@@ -17,6 +17,9 @@ func (msgpackBinding) Name() string {
 }
 
 func (msgpackBinding) Bind(req *http.Request, obj interface{}) error {
+	if err := decodeBody(req); err != nil {
+		return err
+	}
 	if err := codec.NewDecoder(req.Body, new(codec.MsgpackHandle)).Decode(&obj); err != nil {
 		return err
 	}
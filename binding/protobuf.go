@@ -18,6 +18,9 @@ func (protobufBinding) Name() string {
 }
 
 func (protobufBinding) Bind(req *http.Request, obj interface{}) error {
+	if err := decodeBody(req); err != nil {
+		return err
+	}
 
 	buf, err := ioutil.ReadAll(req.Body)
 	if err != nil {
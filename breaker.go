@@ -0,0 +1,284 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+)
+
+// BreakerConfig circuit breaker settings of a called service
+type BreakerConfig struct {
+	// FailureRatio failure ratio opening the breaker, default 0.5
+	FailureRatio float64
+	// MinRequests requests in Interval before the ratio is checked,
+	// default 20
+	MinRequests uint32
+	// Interval cyclic period clearing the counts of the closed breaker,
+	// default 1 minute
+	Interval time.Duration
+	// OpenTimeout time the breaker stays open before probing, default 30s
+	OpenTimeout time.Duration
+	// HalfOpenRequests probe requests allowed while half open, default 1
+	HalfOpenRequests uint32
+	// FailureStatuses ReplyData statuses counted as failures, default
+	// ErrException, ErrTimeout and ErrServiceUnavailable
+	FailureStatuses []int
+	// IsFailure classifies the responses, default the errors other than
+	// context.Canceled and the replies of FailureStatuses
+	IsFailure func(response interface{}, err error) bool
+	// Logger logs the state changes, default logfmt to stderr
+	Logger log.Logger
+}
+
+// BreakerInfo state of a circuit breaker
+type BreakerInfo struct {
+	Name                 string `json:"name"`
+	Service              string `json:"service"`
+	State                string `json:"state"`
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+}
+
+type breakerEntry struct {
+	service string
+	cb      *gobreaker.CircuitBreaker
+}
+
+// breakerTransition state change of a circuit breaker
+type breakerTransition struct {
+	from, to gobreaker.State
+}
+
+// clientBreaker circuit breaker of a client endpoint, the state changes are
+// logged with the context of the request seeing them
+type clientBreaker struct {
+	*gobreaker.CircuitBreaker
+	service string
+	logger  log.Logger
+
+	lock    sync.Mutex
+	pending []breakerTransition
+}
+
+// logTransitions log the pending state changes with the logger of ctx
+func (cb *clientBreaker) logTransitions(ctx context.Context) {
+	cb.lock.Lock()
+	pending := cb.pending
+	cb.pending = nil
+	cb.lock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	logger := ContextLogger(ctx, cb.logger)
+	for _, t := range pending {
+		logger.Log(
+			"circuit_breaker", cb.Name(),
+			"service", cb.service,
+			"from", t.from.String(),
+			"to", t.to.String())
+	}
+}
+
+// Close removes the breaker from the registry, unless it was replaced
+func (cb *clientBreaker) Close() error {
+	breakerLock.Lock()
+	if e, ok := breakers[cb.Name()]; ok && e.cb == cb.CircuitBreaker {
+		delete(breakers, cb.Name())
+	}
+	breakerLock.Unlock()
+	return nil
+}
+
+// errReplyFailure marks failed replies for gobreaker
+var errReplyFailure = errors.New("reply failure")
+
+var (
+	breakerLock    sync.RWMutex
+	breakerConfigs = map[string]BreakerConfig{}
+	breakers       = map[string]breakerEntry{}
+
+	breakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Subsystem: "client",
+			Name:      "circuit_transitions_total",
+			Help:      "Circuit breaker state changes.",
+		},
+		[]string{"service", "from", "to"})
+)
+
+func init() {
+	prometheus.MustRegister(breakerTransitions)
+}
+
+// SetBreakerConfig set the circuit breaker settings of service, the name
+// given with ClientTarget or the host of the instance. An empty service
+// sets the default settings.
+func SetBreakerConfig(service string, cfg BreakerConfig) {
+	breakerLock.Lock()
+	breakerConfigs[service] = cfg
+	breakerLock.Unlock()
+}
+
+// ClientBreaker sets the circuit breaker settings of the client, it takes
+// precedence over SetBreakerConfig
+func ClientBreaker(cfg BreakerConfig) ClientOption {
+	return func(o *clientOptions) { o.breaker = &cfg }
+}
+
+// breakerConfig returns the settings of service with defaults
+func breakerConfig(service string, o *clientOptions) BreakerConfig {
+	var cfg BreakerConfig
+	if o.breaker != nil {
+		cfg = *o.breaker
+	} else {
+		var ok bool
+		breakerLock.RLock()
+		if cfg, ok = breakerConfigs[service]; !ok {
+			cfg = breakerConfigs[""]
+		}
+		breakerLock.RUnlock()
+	}
+	if cfg.FailureRatio <= 0 {
+		cfg.FailureRatio = 0.5
+	}
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenRequests == 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	if cfg.FailureStatuses == nil {
+		cfg.FailureStatuses = []int{
+			ErrException, ErrTimeout, ErrServiceUnavailable}
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = defaultClassifier(
+			cfg.FailureStatuses, context.Canceled)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	}
+	return cfg
+}
+
+// newBreaker new circuit breaker name of service, it replaces the breaker
+// of the same name in the registry
+func newBreaker(
+	name, service string,
+	cfg BreakerConfig) *clientBreaker {
+	cb := &clientBreaker{service: service, logger: cfg.Logger}
+	cb.CircuitBreaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        name,
+		MaxRequests: cfg.HalfOpenRequests,
+		Interval:    cfg.Interval,
+		Timeout:     cfg.OpenTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.Requests >= cfg.MinRequests &&
+				float64(counts.TotalFailures) >=
+					cfg.FailureRatio*float64(counts.Requests)
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			// called under the lock of the breaker, it is logged by the
+			// request once done
+			cb.lock.Lock()
+			cb.pending = append(cb.pending, breakerTransition{from, to})
+			cb.lock.Unlock()
+			breakerTransitions.WithLabelValues(
+				metricsLabel("service", service),
+				from.String(), to.String()).Inc()
+			circuitStateChange(name, from, to)
+		},
+		IsSuccessful: func(err error) bool {
+			return err == nil ||
+				err != errReplyFailure && !cfg.IsFailure(nil, err)
+		},
+	})
+	breakerLock.Lock()
+	breakers[name] = breakerEntry{service: service, cb: cb.CircuitBreaker}
+	breakerLock.Unlock()
+	return cb
+}
+
+// breakerMiddleware circuit breaker counting the failures of isFailure,
+// failed replies are returned to the caller as they are
+func breakerMiddleware(
+	cb *clientBreaker,
+	isFailure func(interface{}, error) bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			response, err := cb.Execute(func() (interface{}, error) {
+				response, err := next(ctx, request)
+				if err == nil && isFailure(response, nil) {
+					return response, errReplyFailure
+				}
+				return response, err
+			})
+			cb.logTransitions(ctx)
+			if err == errReplyFailure {
+				err = nil
+			}
+			return response, err
+		}
+	}
+}
+
+// Breakers returns the state of the client circuit breakers
+func Breakers() []BreakerInfo {
+	breakerLock.RLock()
+	infos := make([]BreakerInfo, 0, len(breakers))
+	for name, e := range breakers {
+		counts := e.cb.Counts()
+		infos = append(infos, BreakerInfo{
+			Name:                 name,
+			Service:              e.service,
+			State:                e.cb.State().String(),
+			Requests:             counts.Requests,
+			TotalSuccesses:       counts.TotalSuccesses,
+			TotalFailures:        counts.TotalFailures,
+			ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+			ConsecutiveFailures:  counts.ConsecutiveFailures})
+	}
+	breakerLock.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// BreakersHandler admin handler listing the circuit breakers to the
+// requests authorized by "Bearer token", an empty token rejects them all.
+// It is mounted by the service, e.g. on GET /admin/breakers.
+func BreakersHandler(token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			HTTPWriteJSON(w, NewReplyData(ErrUnAuthorized))
+			return
+		}
+		infos := Breakers()
+		HTTPWriteJSON(w, RowsReplyData(len(infos), infos))
+	})
+}
@@ -0,0 +1,109 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/sony/gobreaker"
+)
+
+func TestBreakerIsFailure(t *testing.T) {
+	cfg := breakerConfig("breaker_test", &clientOptions{})
+	canceled := &url.Error{
+		Op: "Get", URL: "http://svc/", Err: context.Canceled}
+	if cfg.IsFailure(nil, canceled) {
+		t.Error("canceled request is a failure")
+	}
+	if !cfg.IsFailure(nil, errors.New("connection refused")) {
+		t.Error("transport error is not a failure")
+	}
+	if !cfg.IsFailure(NewReplyData(ErrException), nil) {
+		t.Error("exception reply is not a failure")
+	}
+	if cfg.IsFailure(NewReplyData(ErrOk), nil) {
+		t.Error("ok reply is a failure")
+	}
+}
+
+// testBreaker breaker opened by 2 failures of 2 requests
+func testBreaker(t *testing.T, name string) *clientBreaker {
+	cfg := breakerConfig(name, &clientOptions{breaker: &BreakerConfig{
+		MinRequests: 2, Logger: log.NewNopLogger()}})
+	cb := newBreaker(name, "breaker_test", cfg)
+	t.Cleanup(func() { cb.Close() })
+	return cb
+}
+
+func TestBreakerOpens(t *testing.T) {
+	cb := testBreaker(t, "breaker_test GET /")
+	e := breakerMiddleware(cb, defaultClassifier([]int{ErrException}))(
+		func(context.Context, interface{}) (interface{}, error) {
+			return NewReplyData(ErrException), nil
+		})
+
+	for i := 0; i < 2; i++ {
+		response, err := e(context.Background(), nil)
+		// the failed reply reaches the caller as it is
+		if err != nil || response.(*ReplyData).Status != ErrException {
+			t.Fatalf("response %v, %v, want the reply", response, err)
+		}
+	}
+	if _, err := e(context.Background(), nil); err != gobreaker.ErrOpenState {
+		t.Errorf("error %v, want the breaker open", err)
+	}
+}
+
+func TestBreakersHandler(t *testing.T) {
+	cb := testBreaker(t, "breaker_test GET /handler")
+	cb.Execute(func() (interface{}, error) { return nil, nil })
+
+	for _, c := range []struct {
+		token, auth string
+		want        int
+	}{
+		{"", "Bearer ", ErrUnAuthorized},
+		{"secret", "", ErrUnAuthorized},
+		{"secret", "Bearer other", ErrUnAuthorized},
+		{"secret", "secret", ErrUnAuthorized},
+		{"secret", "Bearer secret", ErrOk},
+	} {
+		r := httptest.NewRequest("GET", "/admin/breakers", nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		BreakersHandler(c.token).ServeHTTP(w, r)
+		var reply struct {
+			Status int           `json:"status"`
+			Rows   []BreakerInfo `json:"rows"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Status != c.want {
+			t.Errorf("token %q auth %q: status %d, want %d",
+				c.token, c.auth, reply.Status, c.want)
+		}
+		if c.want != ErrOk {
+			if len(reply.Rows) != 0 {
+				t.Errorf("auth %q: breakers listed", c.auth)
+			}
+			continue
+		}
+		found := false
+		for _, info := range reply.Rows {
+			if info.Name == cb.Name() {
+				found = info.State == "closed" && info.Requests == 1
+			}
+		}
+		if !found {
+			t.Errorf("breakers %+v, want %s with 1 request", reply.Rows,
+				cb.Name())
+		}
+	}
+}
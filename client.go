@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// ClientOption sets an optional parameter for client endpoints created by
+// ClientRequestEndpoint, FactoryLoadBalancer and ClientLoadBalancer.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	target       string
+	service      string
+	serviceLimit *RateLimit
+	routeLimit   *RateLimit
+	breaker      *BreakerConfig
+	contentType  string
+	encoder      httptransport.EncodeRequestFunc
+	header       http.Header
+	before       []httptransport.RequestFunc
+	after        []httptransport.ClientResponseFunc
+	retry        *RetryPolicy
+	hedge        *HedgePolicy
+	balance      int
+	hashKey      HashKeyFunc
+	weight       func(instance string) int
+	outlier      *OutlierConfig
+	client       *http.Client
+	// discovered the instance URL was built from a discovered address
+	discovered bool
+}
+
+func newClientOptions(opts []ClientOption) *clientOptions {
+	o := &clientOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ClientTarget sets the name of the called service. It is used as the
+// audience of the service token minted by the client encoders, default
+// the service of the instancer of FactoryLoadBalancer.
+func ClientTarget(name string) ClientOption {
+	return func(o *clientOptions) { o.target = name }
+}
+
+// clientService sets the name of the discovered service, used when no
+// ClientTarget is given
+func clientService(name string) ClientOption {
+	return func(o *clientOptions) { o.service = name }
+}
+
+// clientDiscovered marks the endpoints of discovered addresses
+func clientDiscovered(o *clientOptions) { o.discovered = true }
+
+// serviceName returns the name keying the per service settings and limits
+// of the client, the ClientTarget, the discovered service or host
+func (o *clientOptions) serviceName(host string) string {
+	if o.target != "" {
+		return o.target
+	}
+	if o.service != "" {
+		return o.service
+	}
+	return host
+}
+
+// clientTargetMiddleware carries the called service name to the encoders
+func clientTargetMiddleware(target string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			ctx = context.WithValue(ctx, ContextKeyClientTarget, target)
+			return next(ctx, request)
+		}
+	}
+}
+
+// ClientContentType sets the request encoding of the client by the MIME
+// types of the binding package: JSON, XML, form, msgpack or protobuf
+func ClientContentType(contentType string) ClientOption {
+	return func(o *clientOptions) { o.contentType = contentType }
+}
+
+// ClientEncoder sets the request encoder of the client, it takes precedence
+// over ClientContentType
+func ClientEncoder(enc httptransport.EncodeRequestFunc) ClientOption {
+	return func(o *clientOptions) { o.encoder = enc }
+}
+
+// ClientHeader sets a header sent with every request of the client
+func ClientHeader(key, value string) ClientOption {
+	return func(o *clientOptions) {
+		if o.header == nil {
+			o.header = http.Header{}
+		}
+		o.header.Set(key, value)
+	}
+}
+
+// ClientBefore adds RequestFuncs executed on the request once encoded
+func ClientBefore(before ...httptransport.RequestFunc) ClientOption {
+	return func(o *clientOptions) { o.before = append(o.before, before...) }
+}
+
+// ClientAfter adds ClientResponseFuncs executed on the response before it
+// is decoded
+func ClientAfter(after ...httptransport.ClientResponseFunc) ClientOption {
+	return func(o *clientOptions) { o.after = append(o.after, after...) }
+}
+
+// setClientHeader RequestFunc setting the headers of h
+func setClientHeader(h http.Header) httptransport.RequestFunc {
+	return func(ctx context.Context, req *http.Request) context.Context {
+		for k, v := range h {
+			req.Header[k] = v
+		}
+		return ctx
+	}
+}
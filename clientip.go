@@ -0,0 +1,207 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// client ip headers set by proxies
+const (
+	HTTPHeaderXForwardedFor = `X-Forwarded-For`
+	HTTPHeaderXRealIP       = `X-Real-IP`
+	HTTPHeaderForwarded     = `Forwarded`
+)
+
+// ClientIPConfig client ip resolution config
+type ClientIPConfig struct {
+	// TrustedProxies CIDRs or ips of the proxies whose headers are trusted
+	TrustedProxies []string
+	// Headers checked in order, default X-Forwarded-For, X-Real-IP and
+	// Forwarded
+	Headers []string
+}
+
+var (
+	clientIPLock    sync.RWMutex
+	clientIPHeaders = []string{
+		HTTPHeaderXForwardedFor, HTTPHeaderXRealIP, HTTPHeaderForwarded}
+	trustedProxies []*net.IPNet
+)
+
+// parseCIDRs parse CIDRs, a single ip is a /32 or /128 network
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, errors.New("invalid ip " + c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{
+				IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetClientIPConfig set
+func SetClientIPConfig(cfg ClientIPConfig) error {
+	nets, err := parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	if cfg.Headers == nil {
+		cfg.Headers = []string{
+			HTTPHeaderXForwardedFor, HTTPHeaderXRealIP, HTTPHeaderForwarded}
+	}
+	clientIPLock.Lock()
+	trustedProxies = nets
+	clientIPHeaders = cfg.Headers
+	clientIPLock.Unlock()
+	return nil
+}
+
+// parseIP parse an ip with an optional port, IPv6 may be in brackets
+func parseIP(s string) net.IP {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+// forwardedFor returns the for= values of the Forwarded header
+func forwardedFor(header string) []string {
+	var addrs []string
+	for _, elem := range strings.Split(header, ",") {
+		for _, pair := range strings.Split(elem, ";") {
+			pair = strings.TrimSpace(pair)
+			if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+				addrs = append(addrs, pair[4:])
+			}
+		}
+	}
+	return addrs
+}
+
+// ClientIP returns the ip of the client, the proxy headers are only read
+// when the request comes from a trusted proxy. The addresses are walked
+// from the nearest hop and the first one not trusted is the client.
+func ClientIP(r *http.Request) string {
+	remote := parseIP(r.RemoteAddr)
+	if remote == nil {
+		return ""
+	}
+
+	clientIPLock.RLock()
+	nets, headers := trustedProxies, clientIPHeaders
+	clientIPLock.RUnlock()
+	if !containsIP(nets, remote) {
+		return remote.String()
+	}
+
+	for _, name := range headers {
+		name = http.CanonicalHeaderKey(name)
+		var addrs []string
+		for _, v := range r.Header[name] {
+			switch name {
+			case HTTPHeaderForwarded:
+				addrs = append(addrs, forwardedFor(v)...)
+			default:
+				addrs = append(addrs, strings.Split(v, ",")...)
+			}
+		}
+		var client net.IP
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := parseIP(addrs[i])
+			if ip == nil {
+				// unknown or obfuscated hop, the chain stops here
+				break
+			}
+			client = ip
+			if !containsIP(nets, ip) {
+				break
+			}
+		}
+		if client != nil {
+			return client.String()
+		}
+	}
+	return remote.String()
+}
+
+// ContextClientIP returns the client ip resolved by PopulateRequestContext
+func ContextClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(ContextKeyRequestClientIP).(string)
+	return ip
+}
+
+// IPFilter allow and deny lists of client ips
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPFilter new IPFilter of CIDRs or ips. Denied ips are rejected, and
+// when allow is not empty only the ips in it are accepted.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	a, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &IPFilter{allow: a, deny: d}, nil
+}
+
+// Allowed reports whether ip passes the filter
+func (f *IPFilter) Allowed(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	if containsIP(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, addr)
+}
+
+// IPFilterMiddleware reject clients not allowed by f, wrap the handler of a
+// route group with it
+func IPFilterMiddleware(f *IPFilter) HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !f.Allowed(ClientIP(r)) {
+				HTTPWriteJSON(w, NewReplyData(ErrNotAllowed))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testTrustedProxies trusts proxies while the test runs
+func testTrustedProxies(t *testing.T, proxies ...string) {
+	if err := SetClientIPConfig(
+		ClientIPConfig{TrustedProxies: proxies}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetClientIPConfig(ClientIPConfig{}) })
+}
+
+func TestClientIP(t *testing.T) {
+	testTrustedProxies(t, "10.0.0.0/8", "2001:db8::1")
+	for _, c := range []struct {
+		remote, header, value, want string
+	}{
+		// the headers of untrusted peers are ignored
+		{"1.2.3.4:80", HTTPHeaderXForwardedFor, "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:80", "", "", "10.0.0.1"},
+		{"10.0.0.1:80", HTTPHeaderXForwardedFor, "5.6.7.8", "5.6.7.8"},
+		// a spoofed first hop is skipped from the nearest one
+		{"10.0.0.1:80", HTTPHeaderXForwardedFor,
+			"9.9.9.9, 5.6.7.8, 10.0.0.2", "5.6.7.8"},
+		{"10.0.0.1:80", HTTPHeaderXForwardedFor,
+			"10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"10.0.0.1:80", HTTPHeaderXForwardedFor,
+			"5.6.7.8, unknown", "10.0.0.1"},
+		{"10.0.0.1:80", HTTPHeaderXRealIP, "5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:80", HTTPHeaderForwarded,
+			`for="[2001:db8::2]:443";proto=https, for=10.0.0.2`,
+			"2001:db8::2"},
+		{"[2001:db8::1]:80", HTTPHeaderXForwardedFor, "5.6.7.8:1234",
+			"5.6.7.8"},
+		{"bad", "", "", ""},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		if got := ClientIP(r); got != c.want {
+			t.Errorf("ClientIP of %s %s: %q = %q, want %q", c.remote,
+				c.header, c.value, got, c.want)
+		}
+	}
+}
+
+func TestClientIPConfigInvalid(t *testing.T) {
+	if err := SetClientIPConfig(ClientIPConfig{
+		TrustedProxies: []string{"10.0.0.300"}}); err == nil {
+		t.Error("no error of an invalid proxy")
+	}
+	if _, err := NewIPFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("no error of an invalid CIDR")
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	f, err := NewIPFilter(
+		[]string{"192.168.0.0/16", "::1"}, []string{"192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, allowed := range map[string]bool{
+		"192.168.2.1": true,
+		"::1":         true,
+		"192.168.1.1": false,
+		"8.8.8.8":     false,
+		"":            false,
+	} {
+		if f.Allowed(ip) != allowed {
+			t.Errorf("Allowed(%q) = %v", ip, !allowed)
+		}
+	}
+	deny, _ := NewIPFilter(nil, []string{"8.8.8.0/24"})
+	if !deny.Allowed("9.9.9.9") || deny.Allowed("8.8.8.8") {
+		t.Error("a deny list alone does not allow the others")
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	testTrustedProxies(t, "10.0.0.1")
+	f, _ := NewIPFilter([]string{"5.6.7.8"}, nil)
+	h := IPFilterMiddleware(f)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HTTPWriteJSON(w, NewReplyData(ErrOk))
+		}))
+	for forwarded, want := range map[string]int{
+		"5.6.7.8": ErrOk,
+		"1.2.3.4": ErrNotAllowed,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:80"
+		r.Header.Set(HTTPHeaderXForwardedFor, forwarded)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if status := testReplyStatus(t, w); status != want {
+			t.Errorf("client %s: status %d, want %d", forwarded, status,
+				want)
+		}
+	}
+}
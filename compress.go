@@ -0,0 +1,271 @@
+package toolkit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// response content encodings
+const (
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+	EncodingGzip   = "gzip"
+)
+
+// CompressConfig response compression config
+type CompressConfig struct {
+	// MinSize responses smaller than it are sent uncompressed, default 1KB
+	MinSize int
+	// Encodings supported encodings by preference, default br, zstd, gzip
+	Encodings []string
+	// SkipTypes content types sent uncompressed, a trailing "/" matches the
+	// whole type, default images, audio, video and archives
+	SkipTypes []string
+}
+
+var defaultSkipTypes = []string{
+	"image/", "audio/", "video/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/zstd", "application/x-7z-compressed", "application/x-rar",
+	"application/x-bzip2", "application/x-xz", "application/pdf"}
+
+// compressEncoder streaming encoder of a content encoding
+type compressEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var compressPools = map[string]*sync.Pool{
+	EncodingBrotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	EncodingZstd: {New: func() interface{} {
+		enc, _ := zstd.NewWriter(
+			nil,
+			zstd.WithEncoderLevel(zstd.SpeedDefault),
+			zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+	EncodingGzip: {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+}
+
+// negotiateEncoding returns the encoding of encodings with the highest
+// quality in the Accept-Encoding header, ties are broken by preference
+func negotiateEncoding(accept string, encodings []string) string {
+	quality := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		quality[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range encodings {
+		q, ok := quality[enc]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the response until MinSize bytes are written,
+// then sends it compressed or as it is
+type compressWriter struct {
+	wrapWriter
+	cfg      *CompressConfig
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	enc      compressEncoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.cfg.MinSize {
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// compressible reports whether the response should be compressed
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	if w.status < http.StatusOK || w.status == http.StatusNoContent ||
+		w.status == http.StatusNotModified ||
+		w.status == http.StatusPartialContent ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+	for _, t := range w.cfg.SkipTypes {
+		if contentType == t ||
+			strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// decide writes the header and the buffered body, compressed when the
+// response is compressible and not smaller than MinSize unless streamed
+func (w *compressWriter) decide(stream bool) error {
+	w.decided = true
+	if w.status == 0 {
+		return nil
+	}
+	h := w.Header()
+	if w.compressible() && (stream || len(w.buf) >= w.cfg.MinSize) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// the encoded body is another representation
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.enc = compressPools[w.encoding].Get().(compressEncoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush implements http.Flusher, streamed responses are compressed
+// regardless of MinSize
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.decide(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	w.wrapWriter.Flush()
+}
+
+// Hijack implements http.Hijacker, the hijacked connection is not
+// compressed
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.wrapWriter.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// close writes the buffered response and ends the encoded stream
+func (w *compressWriter) close() {
+	if w.hijacked {
+		return
+	}
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(nil)
+		compressPools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
+
+// CompressMiddleware compress responses with the encoding negotiated by the
+// Accept-Encoding header
+func CompressMiddleware(cfg CompressConfig) HTTPMiddleware {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = 1024
+	}
+	if cfg.Encodings == nil {
+		cfg.Encodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+	}
+	if cfg.SkipTypes == nil {
+		cfg.SkipTypes = defaultSkipTypes
+	}
+	encodings := make([]string, 0, len(cfg.Encodings))
+	for _, enc := range cfg.Encodings {
+		if _, ok := compressPools[enc]; ok {
+			encodings = append(encodings, enc)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(
+				r.Header.Get("Accept-Encoding"), encodings)
+			if encoding == "" || r.Method == "HEAD" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{
+				wrapWriter: wrapWriter{w},
+				cfg:        &cfg,
+				encoding:   encoding}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package toolkit
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	encodings := []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+	for accept, want := range map[string]string{
+		"":                           "",
+		"gzip":                       EncodingGzip,
+		"gzip, br":                   EncodingBrotli,
+		"GZIP;q=1, br;q=0.5":         EncodingGzip,
+		"br;q=0, gzip":               EncodingGzip,
+		"*":                          EncodingBrotli,
+		"*;q=0.1, zstd;q=0.2":        EncodingZstd,
+		"identity, deflate":          "",
+		"br;q=0, zstd;q=0, gzip;q=0": "",
+	} {
+		if got := negotiateEncoding(accept, encodings); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+// testCompress serves body of contentType through CompressMiddleware
+func testCompress(
+	accept, contentType, body string,
+	flush bool) *httptest.ResponseRecorder {
+	h := CompressMiddleware(CompressConfig{MinSize: 64})(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, body)
+			if flush {
+				w.(http.Flusher).Flush()
+			}
+		}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", accept)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func testDecompress(t *testing.T, encoding string, body io.Reader) string {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch encoding {
+	case EncodingGzip:
+		r, err = gzip.NewReader(body)
+	case EncodingBrotli:
+		r = brotli.NewReader(body)
+	case EncodingZstd:
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(body)
+		if err == nil {
+			defer d.Close()
+		}
+		r = d
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	large := `{"rows":"` + strings.Repeat("x", 100) + `"}`
+	for _, encoding := range []string{
+		EncodingGzip, EncodingBrotli, EncodingZstd} {
+		w := testCompress(encoding, "application/json", large, false)
+		h := w.Header()
+		if h.Get("Content-Encoding") != encoding ||
+			h.Get("Vary") != "Accept-Encoding" || h.Get("ETag") != `W/"v1"` {
+			t.Errorf("%s: headers %v", encoding, h)
+			continue
+		}
+		if got := testDecompress(t, encoding, w.Body); got != large {
+			t.Errorf("%s: decoded %q", encoding, got)
+		}
+	}
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"small":    testCompress("gzip", "application/json", "{}", false),
+		"image":    testCompress("gzip", "image/png", large, false),
+		"identity": testCompress("identity", "application/json", large, false),
+	} {
+		if w.Header().Get("Content-Encoding") != "" ||
+			w.Header().Get("ETag") != `"v1"` {
+			t.Errorf("%s: headers %v, want it sent as it is", name,
+				w.Header())
+		}
+	}
+
+	// a flushed response is streamed compressed regardless of MinSize
+	w := testCompress("gzip", "text/event-stream", "data: 1\n\n", true)
+	if w.Header().Get("Content-Encoding") != EncodingGzip ||
+		testDecompress(t, EncodingGzip, w.Body) != "data: 1\n\n" {
+		t.Errorf("flushed response headers %v", w.Header())
+	}
+}
@@ -0,0 +1,171 @@
+package toolkit
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chuangxin1/httprouter"
+)
+
+// CORSConfig CORS config
+type CORSConfig struct {
+	// AllowedOrigins origins allowed to call the API, "*" allows any origin
+	// and "https://*.example.com" any subdomain of example.com
+	AllowedOrigins []string
+	// AllowedMethods default GET, POST, PUT, PATCH, DELETE
+	AllowedMethods []string
+	// AllowedHeaders request headers allowed in requests, "*" allows any,
+	// default Authorization, Content-Type, X-Request-Id and X-Api-Key
+	AllowedHeaders []string
+	// ExposedHeaders response headers readable by the browser
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and Authorization
+	AllowCredentials bool
+	// MaxAge how long browsers cache the preflight response
+	MaxAge time.Duration
+}
+
+type cors struct {
+	cfg     CORSConfig
+	methods map[string]bool
+	headers map[string]bool
+	any     bool
+}
+
+func newCORS(cfg CORSConfig) *cors {
+	if cfg.AllowedMethods == nil {
+		cfg.AllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	if cfg.AllowedHeaders == nil {
+		cfg.AllowedHeaders = []string{
+			HTTPHeaderAuthorization, "Content-Type",
+			HTTPHeaderRequestID, HTTPHeaderAPIKey}
+	}
+	c := &cors{cfg: cfg, methods: map[string]bool{}, headers: map[string]bool{}}
+	for _, m := range cfg.AllowedMethods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	for _, h := range cfg.AllowedHeaders {
+		if h == "*" {
+			c.any = true
+		}
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return c
+}
+
+// allowOrigin reports whether origin matches the allowed origins
+func (c *cors) allowOrigin(origin string) bool {
+	for _, o := range c.cfg.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if i := strings.Index(o, "*."); i >= 0 {
+			prefix, suffix := o[:i], o[i+1:]
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(origin, prefix) &&
+				strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *cors) setOrigin(w http.ResponseWriter, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if len(c.cfg.AllowedOrigins) == 1 && c.cfg.AllowedOrigins[0] == "*" &&
+		!c.cfg.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.cfg.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers OPTIONS requests of registered routes, the router sets
+// the Allow header to the methods registered for the path
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if origin == "" || method == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !c.allowOrigin(origin) || !c.methods[method] ||
+		!headerContains(w.Header().Get("Allow"), method) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var headers []string
+	for _, h := range strings.Split(
+		r.Header.Get("Access-Control-Request-Headers"), ",") {
+		h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if !c.any && !c.headers[h] {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		headers = append(headers, h)
+	}
+
+	c.setOrigin(w, origin)
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods",
+		strings.Join(c.cfg.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.cfg.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age",
+			strconv.Itoa(durationSeconds(c.cfg.MaxAge)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// headerContains reports whether the comma separated list contains value
+func headerContains(list, value string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware add the CORS headers to responses of allowed origins
+func CORSMiddleware(cfg CORSConfig) HTTPMiddleware {
+	c := newCORS(cfg)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if origin := r.Header.Get("Origin"); origin != "" &&
+				r.Method != "OPTIONS" && c.allowOrigin(origin) {
+				c.setOrigin(w, origin)
+				if len(c.cfg.ExposedHeaders) > 0 {
+					w.Header().Set(
+						"Access-Control-Expose-Headers",
+						strings.Join(c.cfg.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EnableCORS answers the preflight requests of the routes registered on
+// router and returns router wrapped with CORSMiddleware
+func EnableCORS(router *httprouter.Router, cfg CORSConfig) http.Handler {
+	c := newCORS(cfg)
+	router.HandleOPTIONS = true
+	router.GlobalOPTIONS = http.HandlerFunc(c.preflight)
+	return CORSMiddleware(cfg)(router)
+}
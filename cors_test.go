@@ -0,0 +1,93 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testPreflight answers a preflight request of path registered with allow
+// like the router does
+func testPreflight(
+	c *cors,
+	allow, origin, method, headers string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("OPTIONS", "/users", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set("Allow", allow)
+	c.preflight(w, r)
+	return w
+}
+
+func TestCORSPreflight(t *testing.T) {
+	c := newCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute})
+	allow := "GET, POST, OPTIONS"
+
+	w := testPreflight(c, allow, "https://app.example.com", "POST",
+		"content-type, x-request-id")
+	h := w.Header()
+	if w.Code != http.StatusNoContent ||
+		h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Credentials") != "true" ||
+		h.Get("Access-Control-Allow-Headers") !=
+			"Content-Type, X-Request-Id" ||
+		h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight %d %v, want allowed", w.Code, h)
+	}
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"origin": testPreflight(c, allow, "https://example.com", "POST", ""),
+		"suffix": testPreflight(
+			c, allow, "https://app.example.com.evil", "POST", ""),
+		"unregistered method": testPreflight(
+			c, allow, "https://app.example.com", "PUT", ""),
+		"header": testPreflight(
+			c, allow, "https://app.example.com", "POST", "X-Secret"),
+	} {
+		if w.Code != http.StatusForbidden ||
+			w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: preflight %d %v, want forbidden", name, w.Code,
+				w.Header())
+		}
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	serve := func(cfg CORSConfig, origin string) http.Header {
+		h := CORSMiddleware(cfg)(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Header()
+	}
+
+	h := serve(CORSConfig{AllowedOrigins: []string{"*"}}, "https://a.com")
+	if h.Get("Access-Control-Allow-Origin") != "*" ||
+		h.Get("Vary") != "Origin" {
+		t.Errorf("headers %v, want any origin", h)
+	}
+	// credentials echo the origin instead of *
+	h = serve(CORSConfig{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{HTTPHeaderRequestID}}, "https://a.com")
+	if h.Get("Access-Control-Allow-Origin") != "https://a.com" ||
+		h.Get("Access-Control-Expose-Headers") != HTTPHeaderRequestID {
+		t.Errorf("headers %v, want the origin echoed", h)
+	}
+	h = serve(CORSConfig{
+		AllowedOrigins: []string{"https://a.com"}}, "https://b.com")
+	if h.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("headers %v of a denied origin", h)
+	}
+}
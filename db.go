@@ -1,6 +1,7 @@
 package toolkit
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"sync"
@@ -8,6 +9,8 @@ import (
 	//
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DbConfig config
@@ -22,13 +25,17 @@ type DbConfig struct {
 var (
 	config DbConfig
 	db     *sqlx.DB
+	dbRefs int
+	dbLock sync.Mutex
 )
 
 // DB define
 type DB struct {
-	conn *sqlx.DB
-	tx   *sqlx.Tx
-	lock *sync.Mutex
+	conn  *sqlx.DB
+	tx    *sqlx.Tx
+	lock  *sync.Mutex
+	holds *int
+	ctx   context.Context
 }
 
 // SetDbConfig set
@@ -42,7 +49,42 @@ func SetDbConfig(cfg DbConfig) {
 
 // NewDB new DB object
 func NewDB() *DB {
-	return &DB{lock: new(sync.Mutex)}
+	return &DB{
+		lock:  new(sync.Mutex),
+		holds: new(int),
+		ctx:   context.Background()}
+}
+
+// WithContext returns a copy of d using ctx, queries are traced as children
+// of its span
+func (d *DB) WithContext(ctx context.Context) *DB {
+	c := *d
+	c.ctx = ctx
+	return &c
+}
+
+// observe start the span of a database operation, the returned func records
+// the latency and ends the span
+func (d *DB) observe(op, query string) func(error) {
+	begin := time.Now()
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracer().Start(
+		ctx,
+		"db."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", config.Driver),
+			attribute.String("db.statement", query)))
+	return func(err error) {
+		observeDB(op, begin, err)
+		if ErrNoRows(err) {
+			err = nil
+		}
+		finishSpan(span, err)
+	}
 }
 
 // ErrNoRows check norows error
@@ -53,21 +95,54 @@ func ErrNoRows(err error) bool {
 	return false
 }
 
-func connect() (*sqlx.DB, error) {
-	//lock := new(sync.Mutex)
-	//lock.Lock()
-	//defer lock.Unlock()
-	if db != nil {
-		return db, nil
+// acquireDB returns the connection pool shared by the DB objects, opened by
+// the first holder
+func acquireDB() (*sqlx.DB, error) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if db == nil {
+		conn, err := sqlx.Connect(config.Driver, config.DNS)
+		if err != nil {
+			return nil, err
+		}
+		conn.DB.SetMaxOpenConns(config.MaxOpenConns)
+		conn.DB.SetMaxIdleConns(config.MaxIdle)
+		conn.DB.SetConnMaxLifetime(config.MaxLifetime)
+		db = conn
+		trackDBPool(db)
 	}
-	db, err := sqlx.Connect(config.Driver, config.DNS)
-	if err == nil {
-		db.DB.SetMaxOpenConns(config.MaxOpenConns)
-		db.DB.SetMaxIdleConns(config.MaxIdle)
-		db.DB.SetConnMaxLifetime(config.MaxLifetime)
-		db.Ping()
+	dbRefs++
+	return db, nil
+}
+
+// releaseDB drop a hold of conn, the pool is closed with the last one. The
+// holds of a pool closed by CloseDB are ignored
+func releaseDB(conn *sqlx.DB) error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if conn == nil || conn != db {
+		return nil
+	}
+	if dbRefs--; dbRefs > 0 {
+		return nil
+	}
+	untrackDBPool(db)
+	err := db.Close()
+	db = nil
+	return err
+}
+
+// CloseDB close the shared connection pool, the next query opens a new one
+func CloseDB() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if db == nil {
+		return nil
 	}
-	return db, err
+	untrackDBPool(db)
+	err := db.Close()
+	db, dbRefs = nil, 0
+	return err
 }
 
 // Connect connect to database
@@ -81,13 +156,29 @@ func (d *DB) Connect() (err error) {
 		// */
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	d.conn, err = connect()
+	conn, err := acquireDB()
+	if err != nil {
+		return
+	}
+	if conn != d.conn {
+		// the holds of the previous pool went with it
+		*d.holds = 0
+	}
+	d.conn = conn
+	*d.holds++
 	return
 }
 
-// Close close database connect
+// Close release the hold of d on the shared pool taken by Connect, the pool
+// is closed when no DB holds it anymore
 func (d *DB) Close() {
-	d.conn.Close()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if *d.holds == 0 {
+		return
+	}
+	*d.holds--
+	releaseDB(d.conn)
 }
 
 // BeginTrans begin trans
@@ -109,7 +200,10 @@ func (d *DB) Rollback() error {
 func (d *DB) TransExec(
 	query string,
 	args interface{}) (LastInsertId, RowsAffected int64, err error) {
-	if rs, err := d.tx.NamedExec(query, args); err == nil {
+	done := d.observe("exec", query)
+	defer func() { done(err) }()
+	var rs sql.Result
+	if rs, err = d.tx.NamedExec(query, args); err == nil {
 		RowsAffected, _ = rs.RowsAffected()
 		LastInsertId, _ = rs.LastInsertId()
 	}
@@ -117,12 +211,17 @@ func (d *DB) TransExec(
 }
 
 // Rows get rows
-func (d *DB) Rows(dest interface{}, query string, args interface{}) error {
-	err := d.Connect()
+func (d *DB) Rows(
+	dest interface{},
+	query string,
+	args interface{}) (err error) {
+	done := d.observe("rows", query)
+	defer func() { done(err) }()
+	err = d.Connect()
 	if err != nil {
 		return err
 	}
-	defer d.conn.Close()
+	defer d.Close()
 
 	nstmt, err := d.conn.PrepareNamed(query)
 	if err != nil {
@@ -136,12 +235,17 @@ func (d *DB) Rows(dest interface{}, query string, args interface{}) error {
 }
 
 // Row get row
-func (d *DB) Row(dest interface{}, query string, args interface{}) error {
-	err := d.Connect()
+func (d *DB) Row(
+	dest interface{},
+	query string,
+	args interface{}) (err error) {
+	done := d.observe("row", query)
+	defer func() { done(err) }()
+	err = d.Connect()
 	if err != nil {
 		return err
 	}
-	defer d.conn.Close()
+	defer d.Close()
 
 	nstmt, err := d.conn.PrepareNamed(query)
 	if err != nil {
@@ -158,13 +262,16 @@ func (d *DB) Row(dest interface{}, query string, args interface{}) error {
 func (d *DB) Insert(
 	query string,
 	args interface{}) (LastInsertId, RowsAffected int64, err error) {
+	done := d.observe("insert", query)
+	defer func() { done(err) }()
 	err = d.Connect()
 	if err != nil {
 		return
 	}
-	defer d.conn.Close()
+	defer d.Close()
 
-	if rs, err := d.conn.NamedExec(query, args); err == nil {
+	var rs sql.Result
+	if rs, err = d.conn.NamedExec(query, args); err == nil {
 		LastInsertId, _ = rs.LastInsertId()
 		RowsAffected, _ = rs.RowsAffected()
 	}
@@ -175,13 +282,16 @@ func (d *DB) Insert(
 func (d *DB) Update(
 	query string,
 	args interface{}) (RowsAffected int64, err error) {
+	done := d.observe("update", query)
+	defer func() { done(err) }()
 	err = d.Connect()
 	if err != nil {
 		return
 	}
-	defer d.conn.Close()
+	defer d.Close()
 
-	if rs, err := d.conn.NamedExec(query, args); err == nil {
+	var rs sql.Result
+	if rs, err = d.conn.NamedExec(query, args); err == nil {
 		RowsAffected, _ = rs.RowsAffected()
 	}
 	return
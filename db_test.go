@@ -0,0 +1,162 @@
+package toolkit
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeDriver fails the statements containing "fail"
+type fakeDriver struct{}
+
+var errFakeExec = errors.New("fake exec failed")
+
+func init() {
+	sql.Register("toolkit_fake", fakeDriver{})
+}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{query}, nil
+}
+
+func (fakeConn) Close() error { return nil }
+
+func (fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "fail") {
+		return nil, errFakeExec
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return nil }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func setFakeDB(t *testing.T) {
+	CloseDB()
+	SetDbConfig(DbConfig{Driver: "toolkit_fake", DNS: "test"})
+	t.Cleanup(func() { CloseDB() })
+}
+
+func TestDBExecErrors(t *testing.T) {
+	setFakeDB(t)
+	args := map[string]interface{}{"id": 1}
+	d := NewDB()
+
+	if _, _, err := d.Insert("insert fail", args); err != errFakeExec {
+		t.Errorf("Insert error %v, want %v", err, errFakeExec)
+	}
+	if _, err := d.Update("update fail", args); err != errFakeExec {
+		t.Errorf("Update error %v, want %v", err, errFakeExec)
+	}
+	rows, err := d.Update("update ok", args)
+	if err != nil || rows != 1 {
+		t.Errorf("Update = %d, %v, want 1 row", rows, err)
+	}
+
+	if err := d.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	d.BeginTrans()
+	if _, _, err := d.TransExec("insert fail", args); err != errFakeExec {
+		t.Errorf("TransExec error %v, want %v", err, errFakeExec)
+	}
+	d.Rollback()
+}
+
+func testTrackedPools() int {
+	dbPoolsLock.Lock()
+	defer dbPoolsLock.Unlock()
+	return len(dbPools)
+}
+
+func TestDBSharedPool(t *testing.T) {
+	setFakeDB(t)
+	args := map[string]interface{}{"id": 1}
+	holder, d := NewDB(), NewDB()
+	if err := holder.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	pool := holder.conn
+
+	for i := 0; i < 3; i++ {
+		if _, err := d.Update("update ok", args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.conn != pool {
+		t.Error("Update opened another pool")
+	}
+	if n := testTrackedPools(); n != 1 {
+		t.Errorf("%d pools tracked, want 1", n)
+	}
+
+	// closing d again does not drop the hold of holder
+	d.Close()
+	if n := testTrackedPools(); n != 1 {
+		t.Errorf("%d pools tracked after closing d, want 1", n)
+	}
+	holder.Close()
+	if n := testTrackedPools(); n != 0 {
+		t.Errorf("%d pools tracked after the last Close, want 0", n)
+	}
+	if err := pool.Ping(); err == nil {
+		t.Error("the pool is open after the last Close")
+	}
+}
+
+func TestCloseDB(t *testing.T) {
+	setFakeDB(t)
+	holder, d := NewDB(), NewDB()
+	if err := holder.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CloseDB(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testTrackedPools(); n != 0 {
+		t.Errorf("%d pools tracked after CloseDB, want 0", n)
+	}
+
+	if err := d.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	// the hold of the closed pool does not release the new one
+	holder.Close()
+	if n := testTrackedPools(); n != 1 {
+		t.Errorf("%d pools tracked, want the pool of d", n)
+	}
+	d.Close()
+	if n := testTrackedPools(); n != 0 {
+		t.Errorf("%d pools tracked after the last Close, want 0", n)
+	}
+}
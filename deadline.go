@@ -0,0 +1,133 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// HTTPHeaderRequestTimeout HTTP header carrying the time left to the
+// deadline of the request in milliseconds. It is relative so the clocks of
+// the caller and the service need not agree.
+const HTTPHeaderRequestTimeout = `X-Request-Timeout`
+
+// RequestTimeoutConfig bounds of the timeouts sent by the callers in
+// X-Request-Timeout
+type RequestTimeoutConfig struct {
+	// Min shortest timeout, shorter ones are raised to it, default 100ms
+	Min time.Duration
+	// Max longest timeout, longer ones are lowered to it, default 1 minute
+	Max time.Duration
+}
+
+var (
+	requestTimeoutLock   sync.RWMutex
+	requestTimeoutConfig = RequestTimeoutConfig{
+		Min: 100 * time.Millisecond, Max: time.Minute}
+)
+
+// SetRequestTimeoutConfig set the bounds of the caller timeouts
+func SetRequestTimeoutConfig(cfg RequestTimeoutConfig) {
+	if cfg.Min <= 0 {
+		cfg.Min = 100 * time.Millisecond
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = time.Minute
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	requestTimeoutLock.Lock()
+	requestTimeoutConfig = cfg
+	requestTimeoutLock.Unlock()
+}
+
+// requestDeadline returns the local deadline in unix milliseconds of the
+// timeout header value bounded by RequestTimeoutConfig, empty without a
+// positive timeout
+func requestDeadline(timeout string) string {
+	if timeout == "" {
+		return ""
+	}
+	ms, err := strconv.ParseInt(timeout, 10, 64)
+	if err != nil || ms <= 0 {
+		return ""
+	}
+	requestTimeoutLock.RLock()
+	cfg := requestTimeoutConfig
+	requestTimeoutLock.RUnlock()
+	d := cfg.Max
+	if ms < int64(cfg.Max/time.Millisecond) {
+		d = time.Duration(ms) * time.Millisecond
+	}
+	if d < cfg.Min {
+		d = cfg.Min
+	}
+	deadline := time.Now().Add(d)
+	return strconv.FormatInt(deadline.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// ContextRequestDeadline returns the deadline sent by the caller
+func ContextRequestDeadline(ctx context.Context) (time.Time, bool) {
+	v, _ := ctx.Value(ContextKeyRequestDeadline).(string)
+	if v == "" {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// setRequestDeadline forward the time left to the deadline of ctx to the
+// called service
+func setRequestDeadline(ctx context.Context, req *http.Request) {
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline) / time.Millisecond
+		if left < 0 {
+			left = 0
+		}
+		req.Header.Set(
+			HTTPHeaderRequestTimeout, strconv.FormatInt(int64(left), 10))
+	}
+}
+
+// TimeoutMiddleware bound the endpoint by timeout and by the deadline sent
+// by the caller, whichever is earlier. Requests whose deadline already
+// passed are rejected without calling the endpoint. The endpoint gets the
+// deadline in its context and must return once it is done, its response
+// is then replaced by an ErrTimeout reply. A zero timeout only honors the
+// caller deadline.
+func TimeoutMiddleware(timeout time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			deadline, ok := ContextRequestDeadline(ctx)
+			if timeout > 0 {
+				if d := time.Now().Add(timeout); !ok || d.Before(deadline) {
+					deadline, ok = d, true
+				}
+			}
+			if !ok {
+				return next(ctx, request)
+			}
+			if !time.Now().Before(deadline) {
+				return NewReplyData(ErrTimeout), nil
+			}
+
+			ctx, cancel := context.WithDeadline(ctx, deadline)
+			defer cancel()
+			response, err := next(ctx, request)
+			if ctx.Err() == context.DeadlineExceeded {
+				return NewReplyData(ErrTimeout), nil
+			}
+			return response, err
+		}
+	}
+}
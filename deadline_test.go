@@ -0,0 +1,68 @@
+package toolkit
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRequestDeadlineBounds(t *testing.T) {
+	SetRequestTimeoutConfig(RequestTimeoutConfig{
+		Min: 50 * time.Millisecond, Max: time.Second})
+	defer SetRequestTimeoutConfig(RequestTimeoutConfig{})
+
+	for _, timeout := range []string{"", "0", "-5", "soon"} {
+		if d := requestDeadline(timeout); d != "" {
+			t.Errorf("timeout %q accepted as %s", timeout, d)
+		}
+	}
+	for _, c := range []struct {
+		timeout string
+		want    time.Duration
+	}{
+		{"1", 50 * time.Millisecond},
+		{"200", 200 * time.Millisecond},
+		{"86400000", time.Second},
+	} {
+		ms, err := strconv.ParseInt(requestDeadline(c.timeout), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		left := time.Until(time.Unix(0, ms*int64(time.Millisecond)))
+		if left > c.want || left < c.want-100*time.Millisecond {
+			t.Errorf("timeout %s: %v left, want %v", c.timeout, left, c.want)
+		}
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	returned := false
+	slow := func(ctx context.Context, request interface{}) (
+		interface{}, error) {
+		<-ctx.Done()
+		returned = true
+		return NewReplyData(ErrOk), nil
+	}
+	response, err := TimeoutMiddleware(10*time.Millisecond)(slow)(
+		context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := response.(*ReplyData); r.Status != ErrTimeout {
+		t.Errorf("status %d, want timeout", r.Status)
+	}
+	// the endpoint is done before the timeout reply is written
+	if !returned {
+		t.Error("endpoint still running after the timeout")
+	}
+
+	fast := func(context.Context, interface{}) (interface{}, error) {
+		return NewReplyData(ErrOk), nil
+	}
+	response, _ = TimeoutMiddleware(time.Second)(fast)(
+		context.Background(), nil)
+	if r := response.(*ReplyData); r.Status != ErrOk {
+		t.Errorf("status %d, want ok", r.Status)
+	}
+}
@@ -11,45 +11,77 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/sd"
 	consulsd "github.com/go-kit/kit/sd/consul"
-	"github.com/go-kit/kit/sd/lb"
 )
 
 var (
-	// retry max
+	// default retry max
 	retryMax = 3
-	// retry timeout
+	// default retry timeout
 	retryTimeout = 500 * time.Millisecond
 )
 
 func factory(
 	ctx context.Context,
 	method, router string,
-	dec DecodeResponseFunc) sd.Factory {
+	dec DecodeResponseFunc,
+	opts ...ClientOption) sd.Factory {
 	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		options := opts
 		if !strings.HasPrefix(instance, "http") {
+			// the scheme follows the transport of the service
 			instance = "http://" + instance
+			options = append(opts[:len(opts):len(opts)], clientDiscovered)
 		}
 		tgt, err := url.Parse(instance)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		return ClientRequestEndpoint(ctx, tgt, method, router, dec), nil, nil
+		// the breaker of the instance is dropped with it
+		e, closer := clientRequestEndpoint(
+			ctx, tgt, method, router, dec, options...)
+		return e, closer, nil
 	}
 }
 
-// FactoryLoadBalancer factory load balance
+// FactoryLoadBalancer factory load balance, the instances are followed
+// until ctx is done
 func FactoryLoadBalancer(
 	ctx context.Context,
 	instancer *consulsd.Instancer,
 	method, router string,
 	dec DecodeResponseFunc,
-	logger log.Logger) endpoint.Endpoint {
+	logger log.Logger,
+	opts ...ClientOption) endpoint.Endpoint {
 
-	endpointer := sd.NewEndpointer(
+	if name, ok := consulInstancerNames.Load(instancer); ok {
+		// the instances share the limits and settings of the service
+		opts = append(
+			append([]ClientOption{}, opts...), clientService(name.(string)))
+	}
+	o := newClientOptions(opts)
+	instances := sdInstances(
 		instancer,
-		factory(ctx, method, router, dec),
+		factory(ctx, method, router, dec, opts...),
+		newOutlierDetector(o),
 		logger)
-	balancer := lb.NewRoundRobin(endpointer)
-	return lb.Retry(retryMax, retryTimeout, balancer)
+	if done := ctx.Done(); done != nil {
+		// the instances are followed until ctx is done
+		SafeGo(ctx, func(context.Context) {
+			<-done
+			instances.Close()
+		})
+	}
+	return clientBalancer(instances, o)
+}
+
+// clientBalancer load balance the instances with the strategy, retry and
+// hedging policies of o
+func clientBalancer(
+	instances *instanceSet,
+	o *clientOptions) endpoint.Endpoint {
+	return retryEndpoint(
+		newPicker(instances, o),
+		retryPolicy(o),
+		newHedger(instances, o.hedge))
 }
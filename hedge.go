@@ -0,0 +1,235 @@
+package toolkit
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/sd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// latency samples kept for the hedging percentile
+	hedgeSamples = 1000
+	// samples needed before the percentile replaces Delay
+	hedgeMinSamples = 100
+)
+
+// HedgePolicy hedged requests of the load balanced client endpoints. When
+// a request is slower than the hedging delay the same request is sent to
+// another instance, the first success wins and the others are canceled.
+// Only idempotent requests are hedged.
+type HedgePolicy struct {
+	// Delay before a hedged request is sent
+	Delay time.Duration
+	// Percentile of the observed latencies used as delay once enough
+	// requests completed, e.g. 0.95, zero always uses Delay
+	Percentile float64
+	// MaxHedges hedged requests per call, default 1
+	MaxHedges int
+	// Budget caps the hedged requests, use another budget than the retry
+	// policy, nil is unlimited
+	Budget *RetryBudget
+}
+
+var hedges = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "toolkit",
+		Subsystem: "client",
+		Name:      "hedges_total",
+		Help:      "Hedged requests by outcome, sent, won or budget exhausted.",
+	},
+	[]string{"outcome"})
+
+func init() {
+	prometheus.MustRegister(hedges)
+}
+
+// ClientHedge sets the hedging policy of FactoryLoadBalancer and
+// ClientLoadBalancer
+func ClientHedge(p HedgePolicy) ClientOption {
+	return func(o *clientOptions) { o.hedge = &p }
+}
+
+// hedger sends the hedged requests of a load balanced client
+type hedger struct {
+	policy     HedgePolicy
+	endpointer sd.Endpointer
+
+	lock     sync.Mutex
+	samples  []time.Duration
+	next     int
+	observed int
+	delay    time.Duration
+}
+
+// newHedger new hedger of the instances of endpointer, nil without policy
+func newHedger(endpointer sd.Endpointer, p *HedgePolicy) *hedger {
+	if p == nil || p.Delay <= 0 && p.Percentile <= 0 {
+		return nil
+	}
+	policy := *p
+	if policy.MaxHedges <= 0 {
+		policy.MaxHedges = 1
+	}
+	return &hedger{
+		policy:     policy,
+		endpointer: endpointer,
+		samples:    make([]time.Duration, 0, hedgeSamples),
+		delay:      policy.Delay}
+}
+
+// observe record the latency of a successful request
+func (h *hedger) observe(d time.Duration) {
+	if h.policy.Percentile <= 0 {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hedgeSamples
+	}
+	h.observed++
+	// the percentile is refreshed every hedgeMinSamples requests
+	if h.observed%hedgeMinSamples == 0 {
+		sorted := make([]time.Duration, len(h.samples))
+		copy(sorted, h.samples)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+		h.delay = sorted[int(h.policy.Percentile*float64(len(sorted)-1))]
+	}
+}
+
+// hedgeDelay returns the current delay, zero until known
+func (h *hedger) hedgeDelay() time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.delay
+}
+
+// do call inst and hedge it with other instances returned by next, the
+// first result not failed wins. All the results failed returns the last
+// one.
+func (h *hedger) do(
+	ctx context.Context,
+	inst *lbInstance,
+	next func() (*lbInstance, error),
+	request interface{},
+	failed func(interface{}, error, int) bool) attemptResult {
+	if h.policy.Budget != nil {
+		h.policy.Budget.request()
+	}
+	// cancels the requests still running once a result is taken
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attemptResult, 1+h.policy.MaxHedges)
+	// instances called, a hedge goes to another one
+	called := map[*lbInstance]bool{}
+	launch := func(inst *lbInstance, call *clientCall, hedged bool) {
+		called[inst] = true
+		go func() {
+			begin := time.Now()
+			r := callAttempt(ctx, inst.endpoint, request, call)
+			r.hedged = hedged
+			_, status := r.call.get()
+			// the latency of the canceled losers is not the service's
+			if ctx.Err() == nil && !failed(r.response, r.err, status) {
+				h.observe(time.Since(begin))
+			}
+			results <- r
+		}()
+	}
+	// the method recorded by the first request decides the hedging
+	first := &clientCall{}
+	launch(inst, first, false)
+
+	var (
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+	if d := h.hedgeDelay(); d > 0 {
+		timer = time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	pending, sent := 1, 0
+	var last attemptResult
+	for {
+		select {
+		case r := <-results:
+			pending--
+			_, status := r.call.get()
+			ok := !failed(r.response, r.err, status)
+			if ok || pending == 0 {
+				if ok && r.hedged {
+					hedges.WithLabelValues("won").Inc()
+				}
+				return r
+			}
+			last = r
+		case <-timeout:
+			timeout = nil
+			if !h.hedgeable(first) {
+				continue
+			}
+			hedge := h.another(next, called)
+			if hedge == nil {
+				continue
+			}
+			if h.policy.Budget != nil && !h.policy.Budget.withdraw() {
+				hedges.WithLabelValues("budget_exhausted").Inc()
+				continue
+			}
+			hedges.WithLabelValues("sent").Inc()
+			launch(hedge, &clientCall{}, true)
+			pending++
+			sent++
+			if sent < h.policy.MaxHedges {
+				timer.Reset(h.hedgeDelay())
+				timeout = timer.C
+			}
+		case <-ctx.Done():
+			last.err = ctx.Err()
+			if last.call == nil {
+				last.call = &clientCall{}
+			}
+			return last
+		}
+	}
+}
+
+// another returns an instance of next not called yet, nil when next only
+// returns called ones
+func (h *hedger) another(
+	next func() (*lbInstance, error),
+	called map[*lbInstance]bool) *lbInstance {
+	endpoints, _ := h.endpointer.Endpoints()
+	for i := 0; i < len(endpoints); i++ {
+		inst, err := next()
+		if err != nil {
+			return nil
+		}
+		if !called[inst] {
+			return inst
+		}
+	}
+	return nil
+}
+
+// hedgeable reports whether the request of call may be hedged, it must be
+// idempotent, not streamed and the service must have another instance
+func (h *hedger) hedgeable(call *clientCall) bool {
+	method, _ := call.get()
+	if method == "" || !idempotent(method) || !call.replayable() {
+		return false
+	}
+	endpoints, err := h.endpointer.Endpoints()
+	return err == nil && len(endpoints) > 1
+}
@@ -0,0 +1,100 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testSlowServers two servers where the first request is slow, it returns
+// the requests of each server
+func testSlowServers(t *testing.T, slow time.Duration) (
+	[]*url.URL, []*int32) {
+	var total int32
+	urls := make([]*url.URL, 2)
+	hits := make([]*int32, 2)
+	for i := range urls {
+		n := new(int32)
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(n, 1)
+				if atomic.AddInt32(&total, 1) == 1 {
+					select {
+					case <-time.After(slow):
+					case <-r.Context().Done():
+						return
+					}
+				}
+				HTTPWriteJSON(w, NewReplyData(ErrOk))
+			}))
+		t.Cleanup(srv.Close)
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls[i], hits[i] = u, n
+	}
+	return urls, hits
+}
+
+func testHedgeEndpoints(urls []*url.URL, method string) []endpoint.Endpoint {
+	endpoints := make([]endpoint.Endpoint, len(urls))
+	for i, u := range urls {
+		endpoints[i] = ClientRequestEndpoint(
+			context.Background(), u, method, "/", HTTPDecodeResponse)
+	}
+	return endpoints
+}
+
+func TestHedgeAnotherInstance(t *testing.T) {
+	urls, hits := testSlowServers(t, 5*time.Second)
+	sent := testutil.ToFloat64(hedges.WithLabelValues("sent"))
+	won := testutil.ToFloat64(hedges.WithLabelValues("won"))
+	e := ClientLoadBalancer(
+		testHedgeEndpoints(urls, "GET"),
+		ClientHedge(HedgePolicy{Delay: 20 * time.Millisecond}))
+
+	begin := time.Now()
+	response, err := e(context.Background(), struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("hedged request took %v", d)
+	}
+	if r := response.(ReplyData); r.Status != ErrOk {
+		t.Errorf("status %d, want ok", r.Status)
+	}
+	for i, n := range hits {
+		if got := atomic.LoadInt32(n); got != 1 {
+			t.Errorf("server %d got %d requests, want 1", i, got)
+		}
+	}
+	if n := testutil.ToFloat64(hedges.WithLabelValues("sent")) - sent; n != 1 {
+		t.Errorf("%v hedges sent, want 1", n)
+	}
+	if n := testutil.ToFloat64(hedges.WithLabelValues("won")) - won; n != 1 {
+		t.Errorf("%v hedges won, want 1", n)
+	}
+}
+
+func TestHedgeNonIdempotent(t *testing.T) {
+	urls, hits := testSlowServers(t, 100*time.Millisecond)
+	e := ClientLoadBalancer(
+		testHedgeEndpoints(urls, "POST"),
+		ClientHedge(HedgePolicy{Delay: 10 * time.Millisecond}))
+
+	if _, err := e(context.Background(), struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(hits[0]) + atomic.LoadInt32(hits[1]); n != 1 {
+		t.Errorf("%d requests of POST, want 1", n)
+	}
+}
@@ -0,0 +1,236 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	// HTTPHeaderIdempotencyKey HTTP header Idempotency-Key
+	HTTPHeaderIdempotencyKey = `Idempotency-Key`
+	// HTTPHeaderIdempotentReplayed set on replayed responses
+	HTTPHeaderIdempotentReplayed = `Idempotent-Replayed`
+
+	// poll interval of requests waiting for a concurrent duplicate
+	idempotencyPoll = 50 * time.Millisecond
+)
+
+// KEYS[1] lock, ARGV[1] lock token
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// IdempotencyConfig idempotency config
+type IdempotencyConfig struct {
+	// Prefix namespaces the redis keys, default "idempotency"
+	Prefix string
+	// TTL how long responses are replayed, default 24h
+	TTL time.Duration
+	// LockTTL max time a request holds the key lock, default 30s
+	LockTTL time.Duration
+	// Wait max time a concurrent duplicate waits for the first request,
+	// default 10s
+	Wait time.Duration
+	// Methods honoring the header, default POST
+	Methods []string
+	// MaxBodySize largest request body read to fingerprint the request,
+	// default 10MB
+	MaxBodySize int64
+}
+
+// idempotencyRecord stored response of a request
+type idempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// recordWriter tees the response into a record
+type recordWriter struct {
+	wrapWriter
+	record idempotencyRecord
+	body   bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(code int) {
+	if w.record.Status == 0 {
+		w.record.Status = code
+		w.record.Header = http.Header{}
+		for k, v := range w.Header() {
+			w.record.Header[k] = v
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	if w.record.Status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func replayRecord(w http.ResponseWriter, rec idempotencyRecord) {
+	for k, v := range rec.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(HTTPHeaderIdempotentReplayed, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+// getIdempotencyRecord returns the stored record of key, if any
+func getIdempotencyRecord(
+	cache *RedisCache,
+	key string) (rec idempotencyRecord, ok bool) {
+	data, err := cache.client().Get(key).Result()
+	if err != nil {
+		return
+	}
+	ok = json.Unmarshal([]byte(data), &rec) == nil
+	return
+}
+
+// IdempotencyMiddleware honor the Idempotency-Key header. The first request
+// of a key runs and its response is stored, repeats with the same payload
+// replay it, repeats with another payload are rejected and concurrent
+// duplicates wait for the first one. The keys are scoped by the access or
+// service token of the caller, anonymous requests with a key are rejected.
+func IdempotencyMiddleware(
+	cache *RedisCache,
+	cfg IdempotencyConfig) HTTPMiddleware {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "idempotency"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.LockTTL <= 0 {
+		cfg.LockTTL = 30 * time.Second
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = 10 * time.Second
+	}
+	if cfg.Methods == nil {
+		cfg.Methods = []string{"POST"}
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = 10 << 20
+	}
+	methods := map[string]bool{}
+	for _, m := range cfg.Methods {
+		methods[m] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HTTPHeaderIdempotencyKey)
+			if key == "" || !methods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// keys are scoped by the caller credentials
+			ctx := PopulateRequestContext(r.Context(), r)
+			identity, _ := ctx.Value(ContextKeyAccessToken).(string)
+			token, _ := ctx.Value(ContextKeyRequestServiceToken).(string)
+			if identity == "" && token != "" {
+				// the service tokens are renewed, the service is the caller
+				if tok, err := ParseServiceToken(token); err == nil {
+					identity = "service:" + tok.Service
+				}
+			}
+			if identity == "" {
+				HTTPWriteJSON(w, NewReplyData(ErrUnAuthorized))
+				return
+			}
+			body, err := ioutil.ReadAll(
+				http.MaxBytesReader(w, r.Body, cfg.MaxBodySize))
+			if err != nil {
+				HTTPWriteJSON(w, ErrReplyData(ErrParamsError, err.Error()))
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			scope := SHA1(identity + "\n" + key)
+			storeKey := cfg.Prefix + ":" + scope
+			lockKey := storeKey + ":lock"
+			fingerprint := SHA2(r.Method + "\n" + r.URL.RequestURI() +
+				"\n" + string(body))
+
+			// replayed answers r with the stored response, if any
+			replayed := func() bool {
+				rec, ok := getIdempotencyRecord(cache, storeKey)
+				if !ok {
+					return false
+				}
+				if rec.Fingerprint != fingerprint {
+					HTTPWriteJSON(w, NewReplyData(ErrIdempotencyKeyReused))
+				} else {
+					replayRecord(w, rec)
+				}
+				return true
+			}
+
+			lockToken := NewRequestID()
+			deadline := time.Now().Add(cfg.Wait)
+			for {
+				if replayed() {
+					return
+				}
+				locked, err := cache.SetNX(lockKey, lockToken, cfg.LockTTL)
+				if err != nil {
+					// redis unavailable, run without idempotency
+					next.ServeHTTP(w, r)
+					return
+				}
+				if locked {
+					break
+				}
+				if time.Now().After(deadline) {
+					HTTPWriteJSON(w, NewReplyData(ErrRequestInProgress))
+					return
+				}
+				select {
+				case <-time.After(idempotencyPoll):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			defer cache.Eval(unlockScript, []string{lockKey}, lockToken)
+			// the first request may have stored its response and released
+			// the lock since the record was read
+			if replayed() {
+				return
+			}
+
+			rw := &recordWriter{wrapWriter: wrapWriter{w}}
+			next.ServeHTTP(rw, r)
+
+			if rw.record.Status == 0 ||
+				rw.record.Status >= http.StatusInternalServerError {
+				return
+			}
+			// exceptions may succeed when retried
+			var reply ReplyData
+			if json.Unmarshal(rw.body.Bytes(), &reply) == nil &&
+				reply.Status == ErrException {
+				return
+			}
+			rw.record.Fingerprint = fingerprint
+			rw.record.Body = rw.body.Bytes()
+			if data, err := json.Marshal(rw.record); err == nil {
+				cache.client().Set(storeKey, string(data), cfg.TTL)
+			}
+		})
+	}
+}
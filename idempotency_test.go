@@ -0,0 +1,115 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+// testIdempotentHandler counts the requests reaching the handler
+func testIdempotentHandler(cache *RedisCache) (http.Handler, *int32) {
+	var runs int32
+	h := IdempotencyMiddleware(cache, IdempotencyConfig{})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&runs, 1)
+			HTTPWriteJSON(w, NewReplyData(ErrOk))
+		}))
+	return h, &runs
+}
+
+func testIdempotentRequest(
+	h http.Handler,
+	token, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.Header.Set(HTTPHeaderIdempotencyKey, key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func testReplyStatus(t *testing.T, w *httptest.ResponseRecorder) int {
+	var reply ReplyData
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("reply %q: %v", w.Body.String(), err)
+	}
+	return reply.Status
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	cache, _ := testRedis(t)
+	h, runs := testIdempotentHandler(cache)
+
+	first := testIdempotentRequest(h, "alice", "k1", `{"n":1}`)
+	again := testIdempotentRequest(h, "alice", "k1", `{"n":1}`)
+	if n := atomic.LoadInt32(runs); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+	if again.Header().Get(HTTPHeaderIdempotentReplayed) != "true" ||
+		again.Body.String() != first.Body.String() {
+		t.Errorf("repeat not replayed: %q", again.Body.String())
+	}
+
+	reused := testIdempotentRequest(h, "alice", "k1", `{"n":2}`)
+	if s := testReplyStatus(t, reused); s != ErrIdempotencyKeyReused {
+		t.Errorf("other payload status %d, want key reused", s)
+	}
+}
+
+func TestIdempotencyScopedByCaller(t *testing.T) {
+	cache, _ := testRedis(t)
+	h, runs := testIdempotentHandler(cache)
+
+	testIdempotentRequest(h, "alice", "k1", `{}`)
+	w := testIdempotentRequest(h, "bob", "k1", `{}`)
+	if n := atomic.LoadInt32(runs); n != 2 {
+		t.Errorf("handler ran %d times, want once per caller", n)
+	}
+	if w.Header().Get(HTTPHeaderIdempotentReplayed) != "" {
+		t.Error("response of another caller replayed")
+	}
+
+	w = testIdempotentRequest(h, "", "k1", `{}`)
+	if s := testReplyStatus(t, w); s != ErrUnAuthorized {
+		t.Errorf("anonymous status %d, want unauthorized", s)
+	}
+	if n := atomic.LoadInt32(runs); n != 2 {
+		t.Error("anonymous request ran the handler")
+	}
+}
+
+func TestIdempotencyLostRace(t *testing.T) {
+	cache, _ := testRedis(t)
+	h, runs := testIdempotentHandler(cache)
+
+	// the first request runs to its end between the record read and the
+	// lock of the duplicate
+	var raced int32
+	cache.c.WrapProcess(func(
+		old func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			args := cmd.Args()
+			if len(args) > 1 && cmd.Name() == "set" &&
+				strings.HasSuffix(args[1].(string), ":lock") &&
+				atomic.CompareAndSwapInt32(&raced, 0, 1) {
+				testIdempotentRequest(h, "alice", "k1", `{}`)
+			}
+			return old(cmd)
+		}
+	})
+
+	w := testIdempotentRequest(h, "alice", "k1", `{}`)
+	if n := atomic.LoadInt32(runs); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+	if w.Header().Get(HTTPHeaderIdempotentReplayed) != "true" {
+		t.Error("duplicate not replayed")
+	}
+}
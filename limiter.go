@@ -0,0 +1,259 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RateLimitTokenBucket token bucket, allows bursts up to Burst requests
+	RateLimitTokenBucket = iota
+	// RateLimitLeakyBucket leaky bucket, spaces requests evenly and queues
+	// up to Burst requests
+	RateLimitLeakyBucket
+	// RateLimitSlidingWindow sliding window counter, backed by redis
+	RateLimitSlidingWindow
+	// RateLimitGCRA generic cell rate algorithm, backed by redis
+	RateLimitGCRA
+)
+
+// ErrRateLimited request rejected by a rate limiter
+var ErrRateLimited = errors.New(`rate limit exceeded`)
+
+// RateLimit rate limit settings
+type RateLimit struct {
+	// Algorithm RateLimitTokenBucket or RateLimitLeakyBucket
+	Algorithm int
+	// Rate allowed requests per second
+	Rate float64
+	// Burst token bucket size or leaky bucket queue length
+	Burst int
+	// Wait blocks until the request is allowed or the context is done,
+	// otherwise the request fails fast with ErrRateLimited. A full leaky
+	// bucket queue fails fast in both cases.
+	Wait bool
+}
+
+// Limiter rate limiter
+type Limiter interface {
+	// Take takes one request from the limiter, with wait it blocks until
+	// the request is allowed or ctx is done.
+	Take(ctx context.Context, wait bool) error
+}
+
+// NewLimiter new local Limiter from RateLimit
+func NewLimiter(limit RateLimit) Limiter {
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	if limit.Algorithm == RateLimitLeakyBucket {
+		return newLeakyBucket(limit.Rate, burst)
+	}
+	r := rate.Limit(limit.Rate)
+	if limit.Rate <= 0 {
+		r = rate.Inf
+	}
+	return &tokenBucket{rate.NewLimiter(r, burst)}
+}
+
+type tokenBucket struct {
+	l *rate.Limiter
+}
+
+func (b *tokenBucket) Take(ctx context.Context, wait bool) error {
+	if !wait {
+		if !b.l.Allow() {
+			return ErrRateLimited
+		}
+		return nil
+	}
+	return b.l.Wait(ctx)
+}
+
+type leakyBucket struct {
+	lock     sync.Mutex
+	interval time.Duration
+	capacity int
+	// last time slot handed out
+	last time.Time
+}
+
+func newLeakyBucket(r float64, capacity int) *leakyBucket {
+	interval := time.Duration(0)
+	if r > 0 {
+		interval = time.Duration(float64(time.Second) / r)
+	}
+	return &leakyBucket{interval: interval, capacity: capacity}
+}
+
+func (b *leakyBucket) Take(ctx context.Context, wait bool) error {
+	b.lock.Lock()
+	now := time.Now()
+	next := b.last.Add(b.interval)
+	if next.Before(now) {
+		next = now
+	}
+	delay := next.Sub(now)
+	// the queue is full, or only the current slot is free without wait
+	queued := b.capacity - 1
+	if !wait {
+		queued = 0
+	}
+	if delay > time.Duration(queued)*b.interval {
+		b.lock.Unlock()
+		return ErrRateLimited
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(next) {
+		b.lock.Unlock()
+		return context.DeadlineExceeded
+	}
+	b.last = next
+	b.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel(next)
+		return ctx.Err()
+	}
+}
+
+// cancel gives back the slot at next when no later slot was handed out
+func (b *leakyBucket) cancel(next time.Time) {
+	b.lock.Lock()
+	if b.last.Equal(next) {
+		b.last = next.Add(-b.interval)
+	}
+	b.lock.Unlock()
+}
+
+var (
+	clientLimitersLock sync.Mutex
+	clientLimiters     = map[string]*clientLimiter{}
+
+	clientRateLimitRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Subsystem: "client_ratelimit",
+			Name:      "requests_total",
+			Help:      "Outbound requests seen by client rate limiters.",
+		},
+		[]string{"target", "route", "result"})
+	clientRateLimitWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "toolkit",
+			Subsystem: "client_ratelimit",
+			Name:      "wait_seconds",
+			Help:      "Time outbound requests waited in client rate limiters.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"target", "route"})
+)
+
+func init() {
+	prometheus.MustRegister(clientRateLimitRequests, clientRateLimitWait)
+}
+
+// clientLimiter limiter of a key and its settings
+type clientLimiter struct {
+	limit RateLimit
+	l     Limiter
+}
+
+// sharedLimiter takes from the limiter registered for key when called, so
+// the endpoints built before a new setting of the key follow it
+type sharedLimiter string
+
+func (key sharedLimiter) Take(ctx context.Context, wait bool) error {
+	clientLimitersLock.Lock()
+	l := clientLimiters[string(key)].l
+	clientLimitersLock.Unlock()
+	return l.Take(ctx, wait)
+}
+
+// sharedClientLimiter returns the limiter for key, shared by all endpoints
+// of a service. The last setting of the key replaces the limiter.
+func sharedClientLimiter(key string, limit RateLimit) Limiter {
+	clientLimitersLock.Lock()
+	defer clientLimitersLock.Unlock()
+	if l, ok := clientLimiters[key]; !ok || l.limit != limit {
+		clientLimiters[key] = &clientLimiter{limit, NewLimiter(limit)}
+	}
+	return sharedLimiter(key)
+}
+
+// ClientRateLimitMiddleware limit outbound requests, target and route are
+// used as metric labels
+func ClientRateLimitMiddleware(
+	l Limiter,
+	wait bool,
+	target, route string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			begin := time.Now()
+			if err := l.Take(ctx, wait); err != nil {
+				clientRateLimitRequests.
+					WithLabelValues(target, route, "rejected").Inc()
+				return nil, err
+			}
+			if wait {
+				clientRateLimitWait.WithLabelValues(target, route).
+					Observe(time.Since(begin).Seconds())
+			}
+			clientRateLimitRequests.
+				WithLabelValues(target, route, "allowed").Inc()
+			return next(ctx, request)
+		}
+	}
+}
+
+// ClientServiceRateLimit limit all requests to the target service, the
+// limiter is shared by every route and instance of the service, named by
+// ClientTarget or its discovery, and follows the last setting
+func ClientServiceRateLimit(limit RateLimit) ClientOption {
+	return func(o *clientOptions) {
+		l := limit
+		o.serviceLimit = &l
+	}
+}
+
+// ClientRouteRateLimit limit requests to the route of the target service
+func ClientRouteRateLimit(limit RateLimit) ClientOption {
+	return func(o *clientOptions) {
+		l := limit
+		o.routeLimit = &l
+	}
+}
+
+// clientRateLimit wraps e with the configured service and route limiters
+func clientRateLimit(
+	e endpoint.Endpoint,
+	o *clientOptions,
+	host, method, router string) endpoint.Endpoint {
+	target := o.serviceName(host)
+	route := method + " " + router
+	if o.routeLimit != nil {
+		l := sharedClientLimiter(target+" "+route, *o.routeLimit)
+		e = ClientRateLimitMiddleware(l, o.routeLimit.Wait, target, route)(e)
+	}
+	if o.serviceLimit != nil {
+		l := sharedClientLimiter(target, *o.serviceLimit)
+		e = ClientRateLimitMiddleware(l, o.serviceLimit.Wait, target, "")(e)
+	}
+	return e
+}
@@ -0,0 +1,122 @@
+package toolkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+func TestTokenBucket(t *testing.T) {
+	l := NewLimiter(RateLimit{Rate: 1, Burst: 2})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := l.Take(ctx, false); err != nil {
+			t.Fatalf("request %d of the burst: %v", i, err)
+		}
+	}
+	if err := l.Take(ctx, false); err != ErrRateLimited {
+		t.Errorf("error %v past the burst, want ErrRateLimited", err)
+	}
+}
+
+func TestLeakyBucket(t *testing.T) {
+	interval := 20 * time.Millisecond
+	l := NewLimiter(RateLimit{
+		Algorithm: RateLimitLeakyBucket, Rate: 50, Burst: 3})
+	ctx := context.Background()
+
+	if err := l.Take(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Take(ctx, false); err != ErrRateLimited {
+		t.Errorf("error %v of the next slot without wait", err)
+	}
+	begin := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := l.Take(ctx, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(begin); d < interval {
+		t.Errorf("2 queued requests took %v, want them spaced", d)
+	}
+	// the next slot is past the deadline
+	ctx, cancel := context.WithTimeout(ctx, interval/2)
+	defer cancel()
+	if err := l.Take(ctx, true); err != context.DeadlineExceeded {
+		t.Errorf("error %v of a slot after the deadline", err)
+	}
+}
+
+func TestLeakyBucketFull(t *testing.T) {
+	l := NewLimiter(RateLimit{
+		Algorithm: RateLimitLeakyBucket, Rate: 10, Burst: 2})
+	ctx := context.Background()
+	done := make(chan error, 1)
+	l.Take(ctx, true)
+	go func() { done <- l.Take(ctx, true) }()
+	time.Sleep(10 * time.Millisecond)
+	// the first slot is taken and the second queued
+	if err := l.Take(ctx, true); err != ErrRateLimited {
+		t.Errorf("error %v of a full queue, want ErrRateLimited", err)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLeakyBucketCancel(t *testing.T) {
+	b := newLeakyBucket(10, 2)
+	ctx := context.Background()
+	b.Take(ctx, true)
+	cctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- b.Take(cctx, true) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("error %v, want context.Canceled", err)
+	}
+	// the slot of the canceled request is given back
+	done = make(chan error, 1)
+	go func() { done <- b.Take(ctx, true) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the slot of the canceled request is lost")
+	}
+}
+
+func TestSharedClientLimiter(t *testing.T) {
+	key := "limiter-test"
+	defer func() {
+		clientLimitersLock.Lock()
+		delete(clientLimiters, key)
+		clientLimitersLock.Unlock()
+	}()
+	limit := RateLimit{Rate: 1, Burst: 1}
+	a := ClientRateLimitMiddleware(
+		sharedClientLimiter(key, limit), false, key, "a")(endpoint.Nop)
+	b := ClientRateLimitMiddleware(
+		sharedClientLimiter(key, limit), false, key, "b")(endpoint.Nop)
+	ctx := context.Background()
+
+	if _, err := a(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b(ctx, nil); err != ErrRateLimited {
+		t.Errorf("error %v, want the limiter shared", err)
+	}
+	// a new setting of the key applies to the endpoints built before
+	sharedClientLimiter(key, RateLimit{Rate: 1, Burst: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := a(ctx, nil); err != nil {
+			t.Errorf("request %d of the new burst: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package toolkit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chuangxin1/httprouter"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-redis/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	// default max distinct values of a metric label
+	defaultMetricsMaxLabelValues = 100
+
+	// metricsOtherLabel replaces label values over the cardinality limit
+	metricsOtherLabel = `other`
+)
+
+// MetricsConfig prometheus metrics config
+type MetricsConfig struct {
+	// Disabled turns off the toolkit metrics, they are on by default
+	Disabled bool
+	// MaxLabelValues max distinct values of the route, target and status
+	// labels, further values are recorded as "other". Default 100.
+	MaxLabelValues int
+}
+
+var (
+	metricsLock   sync.Mutex
+	metricsConfig = MetricsConfig{MaxLabelValues: defaultMetricsMaxLabelValues}
+	metricsLabels = map[string]map[string]bool{}
+
+	serverRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Subsystem: "server",
+			Name:      "requests_total",
+			Help:      "Requests handled by endpoints.",
+		},
+		[]string{"method", "route", "status"})
+	serverErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Subsystem: "server",
+			Name:      "errors_total",
+			Help:      "Requests answered with a non ok ReplyData status.",
+		},
+		[]string{"method", "route", "status"})
+	serverDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "toolkit",
+			Subsystem: "server",
+			Name:      "request_duration_seconds",
+			Help:      "Endpoint latency.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method", "route"})
+
+	clientRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Subsystem: "client",
+			Name:      "requests_total",
+			Help:      "Outbound requests of client endpoints.",
+		},
+		[]string{"target", "route", "status"})
+	clientErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Subsystem: "client",
+			Name:      "errors_total",
+			Help:      "Outbound requests failed or answered with a non ok status.",
+		},
+		[]string{"target", "route", "status"})
+	clientDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "toolkit",
+			Subsystem: "client",
+			Name:      "request_duration_seconds",
+			Help:      "Outbound request latency.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"target", "route"})
+	circuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "toolkit",
+			Subsystem: "client",
+			Name:      "circuit_state",
+			Help:      "Circuit breaker state, 0 closed, 1 half open, 2 open.",
+		},
+		[]string{"name"})
+
+	dbDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "toolkit",
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Database query latency.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"op", "result"})
+
+	redisDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "toolkit",
+			Subsystem: "redis",
+			Name:      "command_duration_seconds",
+			Help:      "Redis command latency.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"command", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		serverRequests, serverErrors, serverDuration,
+		clientRequests, clientErrors, clientDuration, circuitState,
+		dbDuration, redisDuration, dbPoolCollector{})
+}
+
+// SetMetricsConfig set
+func SetMetricsConfig(cfg MetricsConfig) {
+	if cfg.MaxLabelValues <= 0 {
+		cfg.MaxLabelValues = defaultMetricsMaxLabelValues
+	}
+	metricsLock.Lock()
+	metricsConfig = cfg
+	metricsLock.Unlock()
+}
+
+func metricsEnabled() bool {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	return !metricsConfig.Disabled
+}
+
+// metricsLabel caps the distinct values of label name
+func metricsLabel(name, value string) string {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	seen, ok := metricsLabels[name]
+	if !ok {
+		seen = map[string]bool{}
+		metricsLabels[name] = seen
+	}
+	if seen[value] {
+		return value
+	}
+	if len(seen) >= metricsConfig.MaxLabelValues {
+		return metricsOtherLabel
+	}
+	seen[value] = true
+	return value
+}
+
+// replyStatus ReplyData status of an endpoint response
+func replyStatus(response interface{}, err error) string {
+	if status, ok := replyErrorStatus(err); ok {
+		return strconv.Itoa(status)
+	}
+	if err != nil {
+		return "error"
+	}
+	switch r := response.(type) {
+	case *ReplyData:
+		return strconv.Itoa(r.Status)
+	case ReplyData:
+		return strconv.Itoa(r.Status)
+	}
+	return strconv.Itoa(ErrOk)
+}
+
+// MetricsMiddleware record request count, errors by ReplyData status and
+// latency of the route
+func MetricsMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (response interface{}, err error) {
+			if !metricsEnabled() {
+				return next(ctx, request)
+			}
+			defer func(begin time.Time) {
+				method, _ := ctx.Value(ContextKeyRequestMethod).(string)
+				route := metricsLabel("route", httprouter.ContextRoutePath(ctx))
+				status := metricsLabel("status", replyStatus(response, err))
+				serverRequests.WithLabelValues(method, route, status).Inc()
+				if status != strconv.Itoa(ErrOk) {
+					serverErrors.WithLabelValues(method, route, status).Inc()
+				}
+				serverDuration.WithLabelValues(method, route).
+					Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// clientMetricsMiddleware record outbound request count, errors and latency
+func clientMetricsMiddleware(target, route string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (response interface{}, err error) {
+			if !metricsEnabled() {
+				return next(ctx, request)
+			}
+			defer func(begin time.Time) {
+				t := metricsLabel("target", target)
+				r := metricsLabel("route", route)
+				status := metricsLabel("status", replyStatus(response, err))
+				clientRequests.WithLabelValues(t, r, status).Inc()
+				if status != strconv.Itoa(ErrOk) {
+					clientErrors.WithLabelValues(t, r, status).Inc()
+				}
+				clientDuration.WithLabelValues(t, r).
+					Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// circuitStateChange gobreaker OnStateChange recording the breaker state
+func circuitStateChange(name string, from, to gobreaker.State) {
+	if metricsEnabled() {
+		circuitState.WithLabelValues(metricsLabel("target", name)).
+			Set(float64(to))
+	}
+}
+
+// observeDB record the latency of a database operation
+func observeDB(op string, begin time.Time, err error) {
+	if !metricsEnabled() {
+		return
+	}
+	result := "ok"
+	if err != nil && !ErrNoRows(err) {
+		result = "error"
+	}
+	dbDuration.WithLabelValues(op, result).
+		Observe(time.Since(begin).Seconds())
+}
+
+// redisMetricsProcess go-redis WrapProcess hook recording command latency
+func redisMetricsProcess(
+	old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+	return func(cmd redis.Cmder) error {
+		begin := time.Now()
+		err := old(cmd)
+		if metricsEnabled() {
+			result := "ok"
+			if err != nil && err != redis.Nil {
+				result = "error"
+			}
+			redisDuration.WithLabelValues(cmd.Name(), result).
+				Observe(time.Since(begin).Seconds())
+		}
+		return err
+	}
+}
+
+var (
+	dbPoolsLock sync.Mutex
+	dbPools     = map[*sqlx.DB]bool{}
+
+	dbOpenDesc = prometheus.NewDesc(
+		"toolkit_db_open_connections",
+		"Established database connections, in use and idle.",
+		nil, nil)
+	dbInUseDesc = prometheus.NewDesc(
+		"toolkit_db_in_use_connections",
+		"Database connections in use.",
+		nil, nil)
+	dbIdleDesc = prometheus.NewDesc(
+		"toolkit_db_idle_connections",
+		"Idle database connections.",
+		nil, nil)
+	dbWaitCountDesc = prometheus.NewDesc(
+		"toolkit_db_wait_count_total",
+		"Connections waited for.",
+		nil, nil)
+	dbWaitDurationDesc = prometheus.NewDesc(
+		"toolkit_db_wait_duration_seconds_total",
+		"Time blocked waiting for a new connection.",
+		nil, nil)
+)
+
+func trackDBPool(conn *sqlx.DB) {
+	dbPoolsLock.Lock()
+	dbPools[conn] = true
+	dbPoolsLock.Unlock()
+}
+
+func untrackDBPool(conn *sqlx.DB) {
+	dbPoolsLock.Lock()
+	delete(dbPools, conn)
+	dbPoolsLock.Unlock()
+}
+
+// dbPoolCollector collects the stats of the open database pools
+type dbPoolCollector struct{}
+
+func (dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbOpenDesc
+	ch <- dbInUseDesc
+	ch <- dbIdleDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+}
+
+func (dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	var (
+		open, inUse, idle, waitCount int64
+		waitDuration                 time.Duration
+	)
+	dbPoolsLock.Lock()
+	for conn := range dbPools {
+		stats := conn.Stats()
+		open += int64(stats.OpenConnections)
+		inUse += int64(stats.InUse)
+		idle += int64(stats.Idle)
+		waitCount += stats.WaitCount
+		waitDuration += stats.WaitDuration
+	}
+	dbPoolsLock.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		dbOpenDesc, prometheus.GaugeValue, float64(open))
+	ch <- prometheus.MustNewConstMetric(
+		dbInUseDesc, prometheus.GaugeValue, float64(inUse))
+	ch <- prometheus.MustNewConstMetric(
+		dbIdleDesc, prometheus.GaugeValue, float64(idle))
+	ch <- prometheus.MustNewConstMetric(
+		dbWaitCountDesc, prometheus.CounterValue, float64(waitCount))
+	ch <- prometheus.MustNewConstMetric(
+		dbWaitDurationDesc, prometheus.CounterValue, waitDuration.Seconds())
+}
@@ -1,9 +1,12 @@
 package toolkit
 
 import (
+	"bufio"
 	"context"
 	"encoding/base64"
 	"errors"
+	"net"
+	"net/http"
 	"time"
 	//"strings"
 
@@ -42,6 +45,51 @@ func checkAuth(accessToken AccessToken) (CacheAccessToken, error) {
 	return token, err
 }
 
+// HTTPMiddleware http handler middleware
+type HTTPMiddleware func(http.Handler) http.Handler
+
+// HTTPChain wrap h with middlewares, the first middleware is the outermost
+func HTTPChain(h http.Handler, middlewares ...HTTPMiddleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// wrapWriter passes the optional interfaces of the http.ResponseWriter it
+// wraps through, the writers of the middlewares embed it
+type wrapWriter struct {
+	http.ResponseWriter
+}
+
+// Flush implements http.Flusher
+func (w wrapWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w wrapWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push implements http.Pusher
+func (w wrapWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w wrapWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // AuthMiddleware auth
 func AuthMiddleware() endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
@@ -64,6 +112,7 @@ func AuthMiddleware() endpoint.Middleware {
 					return ErrReplyData(ErrUnAuthorized, err.Error()), nil
 				}
 				ctx = context.WithValue(ctx, JWTToken, ctoken)
+				setAccessLogUser(ctx, ctoken.ID)
 				return next(ctx, request)
 			}
 			return NewReplyData(ErrUnAuthorized), nil
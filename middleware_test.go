@@ -0,0 +1,44 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrapWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	base := wrapWriter{rec}
+	for name, w := range map[string]http.ResponseWriter{
+		"status": &statusWriter{wrapWriter: base},
+		"record": &recordWriter{wrapWriter: base},
+		"compress": &compressWriter{
+			wrapWriter: base, cfg: &CompressConfig{}, encoding: "gzip"},
+	} {
+		if _, ok := w.(http.Hijacker); !ok {
+			t.Errorf("%s writer is not a Hijacker", name)
+		}
+		if _, ok := w.(http.Pusher); !ok {
+			t.Errorf("%s writer is not a Pusher", name)
+		}
+		// the recorder neither hijacks nor pushes
+		_, _, err := w.(http.Hijacker).Hijack()
+		if err != http.ErrNotSupported {
+			t.Errorf("%s writer hijacked: %v", name, err)
+		}
+		err = w.(http.Pusher).Push("/", nil)
+		if err != http.ErrNotSupported {
+			t.Errorf("%s writer pushed: %v", name, err)
+		}
+		rec.Flushed = false
+		w.(http.Flusher).Flush()
+		if !rec.Flushed {
+			t.Errorf("%s writer did not flush", name)
+		}
+	}
+	sw := &statusWriter{wrapWriter: base}
+	sw.Hijack()
+	if sw.status != 0 {
+		t.Errorf("status %d of a failed hijack, want none", sw.status)
+	}
+}
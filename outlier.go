@@ -0,0 +1,222 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// outlier ejection reasons
+const (
+	outlierConsecutive = "consecutive_failures"
+	outlierErrorRatio  = "error_ratio"
+	outlierLatency     = "latency"
+)
+
+// OutlierConfig passive health checking of the load balanced instances. An
+// instance failing or slow is ejected from the balancer for a period
+// growing with its ejections, while its health checks may still pass.
+type OutlierConfig struct {
+	// Interval window of the error ratio and latency, default 10s
+	Interval time.Duration
+	// MinRequests requests in Interval before the ratio and latency are
+	// checked, default 10
+	MinRequests int
+	// ErrorRatio failure ratio ejecting the instance, default 0.5
+	ErrorRatio float64
+	// ConsecutiveFailures failures in a row ejecting the instance at once,
+	// default 5
+	ConsecutiveFailures int
+	// Latency average latency ejecting the instance, zero disables it
+	Latency time.Duration
+	// BaseEjection first ejection time, multiplied by the ejections in a
+	// row, default 30s
+	BaseEjection time.Duration
+	// MaxEjection upper bound of the ejection time, default 5m
+	MaxEjection time.Duration
+	// MaxEjectedPercent of the instances ejected at the same time,
+	// default 50
+	MaxEjectedPercent int
+	// FailureStatuses ReplyData statuses counted as failures, default
+	// ErrException, ErrTimeout and ErrServiceUnavailable
+	FailureStatuses []int
+	// IsFailure classifies the responses, default as BreakerConfig and the
+	// requests refused by the client rate limits are not failures either
+	IsFailure func(response interface{}, err error) bool
+	// Logger logs the ejections, default logfmt to stderr
+	Logger log.Logger
+}
+
+var outlierEjections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "toolkit",
+		Subsystem: "client",
+		Name:      "outlier_ejections_total",
+		Help:      "Instances ejected from the balancer by reason.",
+	},
+	[]string{"service", "reason"})
+
+func init() {
+	prometheus.MustRegister(outlierEjections)
+}
+
+// ClientOutlier enables the outlier ejection of FactoryLoadBalancer and
+// ClientLoadBalancer
+func ClientOutlier(cfg OutlierConfig) ClientOption {
+	return func(o *clientOptions) { o.outlier = &cfg }
+}
+
+// outlierDetector ejects the outliers of an instanceSet
+type outlierDetector struct {
+	cfg     OutlierConfig
+	service string
+}
+
+// newOutlierDetector new outlierDetector of o, nil without ClientOutlier
+func newOutlierDetector(o *clientOptions) *outlierDetector {
+	if o.outlier == nil {
+		return nil
+	}
+	cfg := *o.outlier
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.ErrorRatio <= 0 {
+		cfg.ErrorRatio = 0.5
+	}
+	if cfg.ConsecutiveFailures <= 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.BaseEjection <= 0 {
+		cfg.BaseEjection = 30 * time.Second
+	}
+	if cfg.MaxEjection <= 0 {
+		cfg.MaxEjection = 5 * time.Minute
+	}
+	if cfg.MaxEjectedPercent <= 0 {
+		cfg.MaxEjectedPercent = 50
+	}
+	if cfg.FailureStatuses == nil {
+		cfg.FailureStatuses = []int{
+			ErrException, ErrTimeout, ErrServiceUnavailable}
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = defaultClassifier(
+			cfg.FailureStatuses, context.Canceled, ErrRateLimited)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	}
+	return &outlierDetector{cfg: cfg, service: o.serviceName("")}
+}
+
+// outlierStats results of an instance in the current window
+type outlierStats struct {
+	lock        sync.Mutex
+	begin       time.Time
+	requests    int
+	failures    int
+	consecutive int
+	latency     time.Duration
+	// ejections in a row, decreased by every healthy window
+	ejections int
+}
+
+// ejected reports whether the instance is ejected at now
+func (i *lbInstance) ejected(now int64) bool {
+	return atomic.LoadInt64(&i.ejectedUntil) > now
+}
+
+// observe record the result of a request of inst and eject it when it is
+// an outlier
+func (d *outlierDetector) observe(
+	ctx context.Context,
+	s *instanceSet,
+	inst *lbInstance,
+	response interface{},
+	err error,
+	latency time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		// the losers of hedged requests are canceled
+		return
+	}
+	now := time.Now()
+	st := &inst.stats
+	st.lock.Lock()
+	if st.begin.IsZero() {
+		st.begin = now
+	}
+	st.requests++
+	st.latency += latency
+	if d.cfg.IsFailure(response, err) {
+		st.failures++
+		st.consecutive++
+	} else {
+		st.consecutive = 0
+	}
+	requests, failures := st.requests, st.failures
+
+	reason := ""
+	if st.consecutive >= d.cfg.ConsecutiveFailures {
+		reason = outlierConsecutive
+	} else if now.Sub(st.begin) >= d.cfg.Interval {
+		if st.requests >= d.cfg.MinRequests {
+			if float64(st.failures) >=
+				d.cfg.ErrorRatio*float64(st.requests) {
+				reason = outlierErrorRatio
+			} else if d.cfg.Latency > 0 &&
+				st.latency/time.Duration(st.requests) > d.cfg.Latency {
+				reason = outlierLatency
+			}
+		}
+		if reason == "" && st.ejections > 0 {
+			st.ejections--
+		}
+		st.begin, st.requests, st.failures, st.latency = now, 0, 0, 0
+	}
+	if reason == "" || inst.ejected(now.UnixNano()) || !d.allowed(s) {
+		st.lock.Unlock()
+		return
+	}
+	st.ejections++
+	ejection := d.cfg.BaseEjection * time.Duration(st.ejections)
+	if ejection > d.cfg.MaxEjection {
+		ejection = d.cfg.MaxEjection
+	}
+	st.begin, st.requests, st.failures, st.latency = time.Time{}, 0, 0, 0
+	st.consecutive = 0
+	atomic.StoreInt64(&inst.ejectedUntil, now.Add(ejection).UnixNano())
+	st.lock.Unlock()
+
+	ContextLogger(ctx, d.cfg.Logger).Log(
+		"outlier", inst.name,
+		"service", d.service,
+		"reason", reason,
+		"requests", requests,
+		"failures", failures,
+		"ejection", ejection.String())
+	outlierEjections.WithLabelValues(
+		metricsLabel("service", d.service), reason).Inc()
+}
+
+// allowed reports whether one more instance of s may be ejected
+func (d *outlierDetector) allowed(s *instanceSet) bool {
+	snap := s.current()
+	now := time.Now().UnixNano()
+	ejected := 1
+	for _, inst := range snap.instances {
+		if inst.ejected(now) {
+			ejected++
+		}
+	}
+	return ejected*100 <= d.cfg.MaxEjectedPercent*len(snap.instances)
+}
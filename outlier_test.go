@@ -0,0 +1,107 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+)
+
+func TestOutlierIgnoresCanceled(t *testing.T) {
+	var err error
+	failing := func(context.Context, interface{}) (interface{}, error) {
+		return nil, err
+	}
+	o := newClientOptions([]ClientOption{ClientOutlier(OutlierConfig{
+		ConsecutiveFailures: 2,
+		Logger:              log.NewNopLogger()})})
+	s := fixedInstances(
+		[]endpoint.Endpoint{failing, endpoint.Nop}, newOutlierDetector(o))
+	inst := s.current().instances[0]
+
+	// the losers of hedged requests come back as url.Error
+	err = &url.Error{Op: "Get", URL: "http://svc/", Err: context.Canceled}
+	for i := 0; i < 5; i++ {
+		inst.endpoint(context.Background(), nil)
+	}
+	if inst.ejected(time.Now().UnixNano()) {
+		t.Fatal("ejected by canceled requests")
+	}
+	if inst.stats.requests != 0 {
+		t.Errorf("%d canceled requests observed", inst.stats.requests)
+	}
+
+	err = errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		inst.endpoint(context.Background(), nil)
+	}
+	if !inst.ejected(time.Now().UnixNano()) {
+		t.Error("not ejected by consecutive failures")
+	}
+}
+
+// testOutlierSet instances of endpoints with the outlier detection of cfg
+func testOutlierSet(
+	cfg OutlierConfig,
+	endpoints ...endpoint.Endpoint) *instanceSet {
+	cfg.Logger = log.NewNopLogger()
+	o := newClientOptions([]ClientOption{ClientOutlier(cfg)})
+	return fixedInstances(endpoints, newOutlierDetector(o))
+}
+
+func testFailing(context.Context, interface{}) (interface{}, error) {
+	return NewReplyData(ErrServiceUnavailable), nil
+}
+
+func TestOutlierErrorRatio(t *testing.T) {
+	s := testOutlierSet(OutlierConfig{
+		Interval: 20 * time.Millisecond, MinRequests: 4,
+		ConsecutiveFailures: 100},
+		testFailing, endpoint.Nop)
+	inst := s.current().instances[0]
+
+	// every other request fails, the ratio is checked after Interval
+	for i := 0; i < 4; i++ {
+		if i%2 == 0 {
+			inst.endpoint(context.Background(), nil)
+		} else {
+			s.outlier.observe(context.Background(), s, inst,
+				NewReplyData(ErrOk), nil, 0)
+		}
+	}
+	if inst.ejected(time.Now().UnixNano()) {
+		t.Fatal("ejected before the end of the window")
+	}
+	time.Sleep(20 * time.Millisecond)
+	inst.endpoint(context.Background(), nil)
+	if !inst.ejected(time.Now().UnixNano()) {
+		t.Fatal("not ejected by the error ratio")
+	}
+
+	list, _, _ := liveInstances(s)
+	if len(list) != 1 || list[0] != s.current().instances[1] {
+		t.Errorf("%d live instances, want the healthy one", len(list))
+	}
+}
+
+func TestOutlierMaxEjected(t *testing.T) {
+	s := testOutlierSet(OutlierConfig{ConsecutiveFailures: 1},
+		testFailing, testFailing)
+	for _, inst := range s.current().instances {
+		inst.endpoint(context.Background(), nil)
+	}
+	now := time.Now().UnixNano()
+	ejected := 0
+	for _, inst := range s.current().instances {
+		if inst.ejected(now) {
+			ejected++
+		}
+	}
+	if ejected != 1 {
+		t.Errorf("%d of 2 instances ejected, want 50%%", ejected)
+	}
+}
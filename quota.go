@@ -0,0 +1,270 @@
+package toolkit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-redis/redis"
+)
+
+// KEYS[1] current window counter, KEYS[2] previous window counter
+// ARGV[1] limit, ARGV[2] window ms, ARGV[3] now ms
+// returns allowed, remaining, reset ms, retry after ms
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local elapsed = now % window
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+local count = prev * (window - elapsed) / window + cur
+if count + 1 > limit then
+  local retry = window - elapsed
+  if cur + 1 <= limit and prev > 0 then
+    retry = math.ceil(window * (1 - (limit - cur - 1) / prev)) - elapsed
+  end
+  return {0, 0, window - elapsed, retry}
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], window * 2)
+return {1, math.floor(limit - count - 1), window - elapsed, 0}
+`)
+
+// KEYS[1] theoretical arrival time
+// ARGV[1] emission interval ms, ARGV[2] burst, ARGV[3] now ms
+// returns allowed, remaining, reset ms, retry after ms
+var gcraScript = redis.NewScript(`
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tat < now then
+  tat = now
+end
+local newtat = tat + emission
+local diff = now - (newtat - emission * burst)
+if diff < 0 then
+  return {0, 0, math.ceil(tat - now), math.ceil(-diff)}
+end
+redis.call('SET', KEYS[1], string.format('%.3f', newtat),
+  'PX', math.ceil(newtat - now))
+return {1, math.floor(diff / emission), math.ceil(newtat - now), 0}
+`)
+
+// max keys kept by the local fallback limiter
+const quotaLocalKeys = 10000
+
+// Quota inbound request quota
+type Quota struct {
+	// Algorithm RateLimitSlidingWindow or RateLimitGCRA
+	Algorithm int
+	// Limit allowed requests per Period
+	Limit int
+	// Period window length
+	Period time.Duration
+	// Burst GCRA burst size, default Limit
+	Burst int
+}
+
+// QuotaResult result of a quota check
+type QuotaResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// QuotaKeyFunc extracts the quota key from the request context, an empty
+// key skips the quota check
+type QuotaKeyFunc func(ctx context.Context) string
+
+// QuotaLimiter distributed rate limiter shared by all replicas through
+// redis, falls back to a local limiter when redis is unavailable
+type QuotaLimiter struct {
+	cache  *RedisCache
+	prefix string
+	quota  Quota
+
+	lock  sync.Mutex
+	local map[string]Limiter
+}
+
+// NewQuotaLimiter new QuotaLimiter, prefix namespaces the redis keys
+func NewQuotaLimiter(
+	cache *RedisCache,
+	prefix string,
+	quota Quota) *QuotaLimiter {
+	if quota.Burst < 1 {
+		quota.Burst = quota.Limit
+	}
+	return &QuotaLimiter{
+		cache:  cache,
+		prefix: prefix,
+		quota:  quota,
+		local:  map[string]Limiter{}}
+}
+
+// Allow take one request of key
+func (l *QuotaLimiter) Allow(key string) QuotaResult {
+	var (
+		res []interface{}
+		err error
+	)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	period := int64(l.quota.Period / time.Millisecond)
+	// hash tag keeps the window keys in the same cluster slot
+	tag := fmt.Sprintf("%s:{%s}", l.prefix, key)
+
+	if l.cache != nil && period > 0 && l.quota.Limit > 0 {
+		var v interface{}
+		switch l.quota.Algorithm {
+		case RateLimitGCRA:
+			v, err = l.cache.Eval(
+				gcraScript,
+				[]string{tag},
+				float64(period)/float64(l.quota.Limit), l.quota.Burst, now)
+		default:
+			window := now / period
+			v, err = l.cache.Eval(
+				slidingWindowScript,
+				[]string{
+					tag + ":" + strconv.FormatInt(window, 10),
+					tag + ":" + strconv.FormatInt(window-1, 10)},
+				l.quota.Limit, period, now)
+		}
+		res, _ = v.([]interface{})
+	}
+	if l.cache == nil || err != nil || len(res) != 4 {
+		return l.allowLocal(key)
+	}
+
+	allowed, _ := res[0].(int64)
+	remaining, _ := res[1].(int64)
+	reset, _ := res[2].(int64)
+	retry, _ := res[3].(int64)
+	return QuotaResult{
+		Allowed:    allowed == 1,
+		Limit:      l.quota.Limit,
+		Remaining:  int(remaining),
+		Reset:      time.Duration(reset) * time.Millisecond,
+		RetryAfter: time.Duration(retry) * time.Millisecond}
+}
+
+func (l *QuotaLimiter) allowLocal(key string) QuotaResult {
+	l.lock.Lock()
+	lim, ok := l.local[key]
+	if !ok {
+		if len(l.local) >= quotaLocalKeys {
+			l.local = map[string]Limiter{}
+		}
+		lim = NewLimiter(RateLimit{
+			Algorithm: RateLimitTokenBucket,
+			Rate:      float64(l.quota.Limit) / l.quota.Period.Seconds(),
+			Burst:     l.quota.Burst})
+		l.local[key] = lim
+	}
+	l.lock.Unlock()
+
+	res := QuotaResult{Limit: l.quota.Limit, Reset: l.quota.Period}
+	if lim.Take(context.Background(), false) == nil {
+		res.Allowed = true
+		return res
+	}
+	res.RetryAfter = time.Duration(
+		float64(l.quota.Period) / float64(l.quota.Limit))
+	return res
+}
+
+// QuotaKeyUser quota key of the signed in user, use it after AuthMiddleware
+func QuotaKeyUser(ctx context.Context) string {
+	if token, ok := ctx.Value(JWTToken).(CacheAccessToken); ok {
+		return "user:" + strconv.Itoa(token.ID)
+	}
+	return ""
+}
+
+// QuotaKeyIP quota key of the client ip
+func QuotaKeyIP(ctx context.Context) string {
+	addr := ContextClientIP(ctx)
+	if addr == "" {
+		return ""
+	}
+	return "ip:" + addr
+}
+
+// QuotaKeyAPIKey quota key of the X-Api-Key header
+func QuotaKeyAPIKey(ctx context.Context) string {
+	if key, _ := ctx.Value(ContextKeyRequestAPIKey).(string); key != "" {
+		return "key:" + SHA1(key)
+	}
+	return ""
+}
+
+func quotaHeaders(res QuotaResult) http.Header {
+	h := http.Header{}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(durationSeconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(durationSeconds(res.RetryAfter)))
+	}
+	return h
+}
+
+// durationSeconds rounds d up to whole seconds
+func durationSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// QuotaMiddleware limit endpoint requests by key
+func QuotaMiddleware(l *QuotaLimiter, keyFunc QuotaKeyFunc) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			key := keyFunc(ctx)
+			if key == "" {
+				return next(ctx, request)
+			}
+			res := l.Allow(key)
+			for k, v := range quotaHeaders(res) {
+				SetReplyHeader(ctx, k, v[0])
+			}
+			if !res.Allowed {
+				return NewReplyData(ErrTooManyRequests), nil
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// HTTPQuotaMiddleware limit http requests by key
+func HTTPQuotaMiddleware(
+	l *QuotaLimiter,
+	keyFunc QuotaKeyFunc) HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(PopulateRequestContext(r.Context(), r))
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res := l.Allow(key)
+			for k, v := range quotaHeaders(res) {
+				w.Header()[k] = v
+			}
+			if !res.Allowed {
+				HTTPWriteJSON(w, NewReplyData(ErrTooManyRequests))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
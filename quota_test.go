@@ -0,0 +1,100 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQuotaSlidingWindow(t *testing.T) {
+	cache, _ := testRedis(t)
+	l := NewQuotaLimiter(cache, "quota-test", Quota{
+		Algorithm: RateLimitSlidingWindow, Limit: 3, Period: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		res := l.Allow("a")
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d = %+v, want %d remaining", i, res, 2-i)
+		}
+	}
+	res := l.Allow("a")
+	if res.Allowed || res.RetryAfter <= 0 || res.Reset <= 0 {
+		t.Errorf("request past the limit = %+v, want rejected", res)
+	}
+	if res := l.Allow("b"); !res.Allowed {
+		t.Error("the quota of another key was taken")
+	}
+}
+
+func TestQuotaGCRA(t *testing.T) {
+	cache, mr := testRedis(t)
+	l := NewQuotaLimiter(cache, "quota-test", Quota{
+		Algorithm: RateLimitGCRA, Limit: 2, Period: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if res := l.Allow("a"); !res.Allowed {
+			t.Fatalf("request %d of the burst = %+v", i, res)
+		}
+	}
+	res := l.Allow("a")
+	// a request is allowed every half hour
+	if res.Allowed || res.RetryAfter <= 29*time.Minute ||
+		res.RetryAfter > 30*time.Minute {
+		t.Errorf("request past the burst = %+v, want retry in 30m", res)
+	}
+	if !mr.Exists("quota-test:{a}") {
+		t.Error("the quota was not kept in redis")
+	}
+}
+
+func TestQuotaLocalFallback(t *testing.T) {
+	cache, mr := testRedis(t)
+	mr.Close()
+	l := NewQuotaLimiter(cache, "quota-test", Quota{
+		Limit: 1, Period: time.Hour})
+
+	if res := l.Allow("a"); !res.Allowed {
+		t.Fatalf("first request = %+v, want allowed locally", res)
+	}
+	if res := l.Allow("a"); res.Allowed || res.RetryAfter != time.Hour {
+		t.Errorf("second request = %+v, want rejected locally", res)
+	}
+}
+
+func TestHTTPQuotaMiddleware(t *testing.T) {
+	cache, _ := testRedis(t)
+	l := NewQuotaLimiter(cache, "quota-test", Quota{
+		Algorithm: RateLimitGCRA, Limit: 1, Period: time.Minute})
+	h := HTTPQuotaMiddleware(l, QuotaKeyAPIKey)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			HTTPWriteJSON(w, NewReplyData(ErrOk))
+		}))
+	serve := func(key string) (*httptest.ResponseRecorder, int) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if key != "" {
+			r.Header.Set("X-Api-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w, testReplyStatus(t, w)
+	}
+
+	w, status := serve("key")
+	if status != ErrOk || w.Header().Get("RateLimit-Limit") != "1" ||
+		w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("status %d headers %v, want allowed", status, w.Header())
+	}
+	w, status = serve("key")
+	if status != ErrTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("status %d headers %v, want rejected", status, w.Header())
+	}
+	// the requests without a key are not limited
+	for i := 0; i < 2; i++ {
+		if w, status = serve(""); status != ErrOk ||
+			w.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("status %d headers %v of a request without key",
+				status, w.Header())
+		}
+	}
+}
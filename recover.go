@@ -0,0 +1,299 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PanicError recovered panic and the stack of the panicking goroutine
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+	pcs   []uintptr
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value when it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// PanicReporter receives the recovered panics, e.g. to send them to an
+// error tracker. ctx carries the request values when the panic happened
+// while serving a request.
+type PanicReporter interface {
+	Report(ctx context.Context, p *PanicError)
+}
+
+// RecoverConfig panic recovery config
+type RecoverConfig struct {
+	// Logger logs the recovered panics, default logfmt to stderr
+	Logger log.Logger
+	// Reporters called with every recovered panic
+	Reporters []PanicReporter
+}
+
+var (
+	recoverLock   sync.RWMutex
+	recoverConfig = RecoverConfig{
+		Logger: log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))}
+
+	panicsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Name:      "panics_total",
+			Help:      "Recovered panics.",
+		},
+		[]string{"source"})
+)
+
+func init() {
+	prometheus.MustRegister(panicsTotal)
+}
+
+// SetRecoverConfig set
+func SetRecoverConfig(cfg RecoverConfig) {
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	}
+	recoverLock.Lock()
+	recoverConfig = cfg
+	recoverLock.Unlock()
+}
+
+// handlePanic logs and reports the recovered value v, source is one of
+// http, endpoint and goroutine
+func handlePanic(ctx context.Context, source string, v interface{}) {
+	p := &PanicError{Value: v, Stack: debug.Stack()}
+	p.pcs = make([]uintptr, 64)
+	// skip runtime.Callers, handlePanic and the deferred function
+	p.pcs = p.pcs[:runtime.Callers(3, p.pcs)]
+
+	recoverLock.RLock()
+	cfg := recoverConfig
+	recoverLock.RUnlock()
+
+	panicsTotal.WithLabelValues(source).Inc()
+	ContextLogger(ctx, cfg.Logger).Log(
+		"source", source,
+		"panic", fmt.Sprint(v),
+		"stack", string(p.Stack))
+	for _, r := range cfg.Reporters {
+		reportPanic(ctx, r, p)
+	}
+}
+
+// reportPanic calls r, a panicking reporter is ignored
+func reportPanic(ctx context.Context, r PanicReporter, p *PanicError) {
+	defer func() {
+		recover()
+	}()
+	r.Report(ctx, p)
+}
+
+// panicHandler router PanicHandler, replies ErrException without details
+func panicHandler(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if v == http.ErrAbortHandler {
+		// aborts the response, net/http does not log it
+		panic(v)
+	}
+	ctx := PopulateRequestContext(r.Context(), r)
+	w.Header().Set(HTTPHeaderRequestID, ContextRequestID(ctx))
+	handlePanic(ctx, "http", v)
+	HTTPWriteJSON(w, NewReplyData(ErrException))
+}
+
+// HTTPRecoverMiddleware recover panics of handlers not served by a router
+// created with NewRouter
+func HTTPRecoverMiddleware() HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if v := recover(); v != nil {
+					panicHandler(w, r, v)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RecoverMiddleware recover panics of the endpoint, they reply ErrException
+func RecoverMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (response interface{}, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					handlePanic(ctx, "endpoint", v)
+					response, err = NewReplyData(ErrException), nil
+				}
+			}()
+			return next(ctx, request)
+		}
+	}
+}
+
+// SafeGo run fn in a new goroutine, its panics are logged and reported
+// instead of crashing the process
+func SafeGo(ctx context.Context, fn func(ctx context.Context)) {
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				handlePanic(ctx, "goroutine", v)
+			}
+		}()
+		fn(ctx)
+	}()
+}
+
+// sentryReporter PanicReporter sending events to the Sentry store API
+type sentryReporter struct {
+	endpoint string
+	auth     string
+	server   string
+	client   *http.Client
+}
+
+// NewSentryReporter new PanicReporter sending the panics to the Sentry
+// compatible service of dsn, https://key@host/project
+func NewSentryReporter(dsn string) (PanicReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, errors.New("sentry: invalid dsn")
+	}
+	path := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		path, project = "/"+project[:i], project[i+1:]
+	}
+	auth := "Sentry sentry_version=7, sentry_client=toolkit/0.2.0, " +
+		"sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	server, _ := os.Hostname()
+	return &sentryReporter{
+		endpoint: u.Scheme + "://" + u.Host + path +
+			"/api/" + project + "/store/",
+		auth:   auth,
+		server: server,
+		client: &http.Client{Timeout: 5 * time.Second}}, nil
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// sentryFrames stack frames of p, outermost call first
+func sentryFrames(p *PanicError) []sentryFrame {
+	var frames []sentryFrame
+	it := runtime.CallersFrames(p.pcs)
+	for {
+		f, more := it.Next()
+		if f.Function == "runtime.gopanic" {
+			// the frames so far are the recovering functions
+			frames = nil
+			if !more {
+				break
+			}
+			continue
+		}
+		module, function := "", f.Function
+		if i := strings.LastIndex(function, "/"); i >= 0 {
+			if j := strings.Index(function[i:], "."); j >= 0 {
+				module, function = function[:i+j], function[i+j+1:]
+			}
+		} else if j := strings.Index(function, "."); j >= 0 {
+			module, function = function[:j], function[j+1:]
+		}
+		frames = append([]sentryFrame{{
+			Function: function,
+			Module:   module,
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    module != "runtime" && module != "net/http"}},
+			frames...)
+		if !more {
+			break
+		}
+	}
+	return frames
+}
+
+func (s *sentryReporter) Report(ctx context.Context, p *PanicError) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       "fatal",
+		"platform":    "go",
+		"logger":      "toolkit",
+		"server_name": s.server,
+		"exception": map[string]interface{}{
+			"values": []interface{}{map[string]interface{}{
+				"type":       fmt.Sprintf("%T", p.Value),
+				"value":      fmt.Sprint(p.Value),
+				"mechanism":  map[string]interface{}{"type": "panic"},
+				"stacktrace": map[string]interface{}{"frames": sentryFrames(p)},
+			}},
+		},
+	}
+	if id := ContextRequestID(ctx); id != "" {
+		event["tags"] = map[string]string{"request_id": id}
+	}
+	if method, _ := ctx.Value(ContextKeyRequestMethod).(string); method != "" {
+		host, _ := ctx.Value(ContextKeyRequestHost).(string)
+		path, _ := ctx.Value(ContextKeyRequestPath).(string)
+		// the query string may carry credentials and is not sent
+		event["request"] = map[string]string{
+			"method": method,
+			"url":    "http://" + host + path}
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	go func() {
+		req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", s.auth)
+		if res, err := s.client.Do(req); err == nil {
+			res.Body.Close()
+		}
+	}()
+}
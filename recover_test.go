@@ -0,0 +1,174 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type testReporter chan *PanicError
+
+func (r testReporter) Report(ctx context.Context, p *PanicError) {
+	r <- p
+}
+
+type panicReporter struct{}
+
+func (panicReporter) Report(context.Context, *PanicError) {
+	panic("reporter")
+}
+
+// testRecover reports the recovered panics to the returned reporter
+func testRecover(t *testing.T, reporters ...PanicReporter) testReporter {
+	r := make(testReporter, 1)
+	SetRecoverConfig(RecoverConfig{
+		Logger:    log.NewNopLogger(),
+		Reporters: append(reporters, r)})
+	t.Cleanup(func() { SetRecoverConfig(RecoverConfig{}) })
+	return r
+}
+
+func testPanic(t *testing.T, r testReporter) *PanicError {
+	select {
+	case p := <-r:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("the panic was not reported")
+	}
+	return nil
+}
+
+var errTestPanic = errors.New("test panic")
+
+func TestRecoverMiddleware(t *testing.T) {
+	reporter := testRecover(t, panicReporter{})
+	e := RecoverMiddleware()(
+		func(context.Context, interface{}) (interface{}, error) {
+			panic(errTestPanic)
+		})
+
+	response, err := e(context.Background(), nil)
+	if err != nil || response.(*ReplyData).Status != ErrException {
+		t.Errorf("response %v, %v, want ErrException", response, err)
+	}
+	p := testPanic(t, reporter)
+	if !errors.Is(p, errTestPanic) ||
+		!strings.Contains(string(p.Stack), "TestRecoverMiddleware") {
+		t.Errorf("reported %v, want the panic and its stack", p)
+	}
+}
+
+func TestHTTPRecoverMiddleware(t *testing.T) {
+	reporter := testRecover(t)
+	h := HTTPRecoverMiddleware()(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			panic("handler")
+		}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if status := testReplyStatus(t, w); status != ErrException ||
+		strings.Contains(w.Body.String(), "handler") {
+		t.Errorf("reply %s, want ErrException without details", w.Body)
+	}
+	if w.Header().Get(HTTPHeaderRequestID) == "" {
+		t.Error("no request id of the failed request")
+	}
+	if p := testPanic(t, reporter); p.Value != "handler" {
+		t.Errorf("reported %v", p.Value)
+	}
+
+	// an aborted handler aborts the response
+	h = HTTPRecoverMiddleware()(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestSafeGo(t *testing.T) {
+	reporter := testRecover(t)
+	SafeGo(context.Background(), func(context.Context) {
+		panic("goroutine")
+	})
+	if p := testPanic(t, reporter); p.Value != "goroutine" {
+		t.Errorf("reported %v", p.Value)
+	}
+}
+
+type testSentryEvent struct {
+	Request struct {
+		URL string `json:"url"`
+	} `json:"request"`
+	Exception struct {
+		Values []struct {
+			Stacktrace struct {
+				Frames []sentryFrame `json:"frames"`
+			} `json:"stacktrace"`
+		} `json:"values"`
+	} `json:"exception"`
+}
+
+func TestSentryReporter(t *testing.T) {
+	events := make(chan *http.Request, 1)
+	bodies := make(chan testSentryEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var event testSentryEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			events <- r
+			bodies <- event
+		}))
+	defer srv.Close()
+	sentry, err := NewSentryReporter(
+		strings.Replace(srv.URL, "//", "//key:secret@", 1) + "/sentry/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := testRecover(t, sentry)
+
+	r := httptest.NewRequest("GET", "http://api/users?token=secret", nil)
+	h := HTTPRecoverMiddleware()(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			panic("sentry")
+		}))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	testPanic(t, reporter)
+
+	var req *http.Request
+	select {
+	case req = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("no event sent")
+	}
+	event := <-bodies
+	if req.URL.Path != "/sentry/api/42/store/" ||
+		!strings.Contains(req.Header.Get("X-Sentry-Auth"),
+			"sentry_key=key, sentry_secret=secret") {
+		t.Errorf("event sent to %s with auth %q", req.URL,
+			req.Header.Get("X-Sentry-Auth"))
+	}
+	if event.Request.URL != "http://api/users" {
+		t.Errorf("event url %s, want it without query", event.Request.URL)
+	}
+	if len(event.Exception.Values) != 1 {
+		t.Fatalf("%d exceptions, want 1", len(event.Exception.Values))
+	}
+	frames := event.Exception.Values[0].Stacktrace.Frames
+	if len(frames) == 0 || !strings.HasPrefix(
+		frames[len(frames)-1].Function, "TestSentryReporter") {
+		t.Errorf("frames %v, want the panicking function innermost", frames)
+	}
+}
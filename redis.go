@@ -1,6 +1,7 @@
 package toolkit
 
 import (
+	"context"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -82,6 +83,7 @@ func NewRedisCache() *RedisCache {
 		PoolSize:     redisConfig.PoolSize,
 		PoolTimeout:  redisConfig.PoolTimeout,
 	})
+	client.WrapProcess(redisMetricsProcess)
 
 	client.Ping()
 	return &RedisCache{c: client}
@@ -111,11 +113,26 @@ func NewRedisClusterCache() *RedisCache {
 	config.IdleCheckFrequency = redisClusterConfig.IdleCheckFrequency
 
 	client := redis.NewClusterClient(&config)
+	client.WrapProcess(redisMetricsProcess)
 
 	client.Ping()
 	return &RedisCache{cc: client}
 }
 
+// WithContext returns a copy of the cache whose commands are traced as
+// children of the span in ctx
+func (c RedisCache) WithContext(ctx context.Context) *RedisCache {
+	if c.c != nil {
+		c.c = c.c.WithContext(ctx)
+		c.c.WrapProcess(redisTracingProcess(ctx))
+	}
+	if c.cc != nil {
+		c.cc = c.cc.WithContext(ctx)
+		c.cc.WrapProcess(redisTracingProcess(ctx))
+	}
+	return &c
+}
+
 // Get get value from cache
 func (c RedisCache) Get(key string) (string, error) {
 	return c.c.Get(key).Result()
@@ -182,6 +199,30 @@ func (c RedisCache) SubscribeCluster(
 	return
 }
 
+// client returns the cluster client if set, otherwise the single client
+func (c RedisCache) client() redis.Cmdable {
+	if c.cc != nil {
+		return c.cc
+	}
+	return c.c
+}
+
+// Eval run lua script on cache or cluster cache, the script is sent with
+// EVALSHA first and falls back to EVAL
+func (c RedisCache) Eval(
+	script *redis.Script,
+	keys []string,
+	args ...interface{}) (interface{}, error) {
+	return script.Run(c.client(), keys, args...).Result()
+}
+
+// SetNX set key-value to cache or cluster cache if key does not exist
+func (c RedisCache) SetNX(
+	key, value string,
+	expiration time.Duration) (bool, error) {
+	return c.client().SetNX(key, value, expiration).Result()
+}
+
 // Publish publish message
 func (c RedisCache) Publish(channel, message string) error {
 	return c.c.Publish(channel, message).Err()
@@ -0,0 +1,142 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReplyError error of a ReplyData of non zero status, it is returned by the
+// client decode helpers so callers can use errors.As
+type ReplyError struct {
+	Status  int
+	Message string
+	Errs    map[string]string
+}
+
+func (e *ReplyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "reply status %d", e.Status)
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+	if msg, ok := e.Errs["message"]; ok && msg != "" {
+		b.WriteString(": " + msg)
+	}
+	return b.String()
+}
+
+// Err returns the ReplyError of r, nil when the status is ErrOk
+func (r *ReplyData) Err() error {
+	if r.Status == ErrOk {
+		return nil
+	}
+	return &ReplyError{Status: r.Status, Message: r.Message, Errs: r.Errs}
+}
+
+// IsReplyStatus reports whether err is a ReplyError of status
+func IsReplyStatus(err error, status int) bool {
+	var e *ReplyError
+	return errors.As(err, &e) && e.Status == status
+}
+
+// replyErrorStatus returns the status of the ReplyError of err
+func replyErrorStatus(err error) (int, bool) {
+	var e *ReplyError
+	if errors.As(err, &e) {
+		return e.Status, true
+	}
+	return 0, false
+}
+
+// defaultClassifier returns the classifier of the responses failed by a
+// ReplyData of statuses or by an error, except the errors matching ignore
+func defaultClassifier(
+	statuses []int,
+	ignore ...error) func(response interface{}, err error) bool {
+	failures := make(map[int]bool, len(statuses))
+	for _, s := range statuses {
+		failures[s] = true
+	}
+	return func(response interface{}, err error) bool {
+		if status, ok := replyErrorStatus(err); ok {
+			return failures[status]
+		}
+		if err != nil {
+			for _, e := range ignore {
+				if errors.Is(err, e) {
+					return false
+				}
+			}
+			return true
+		}
+		switch r := response.(type) {
+		case *ReplyData:
+			return r != nil && failures[r.Status]
+		case ReplyData:
+			return failures[r.Status]
+		}
+		return false
+	}
+}
+
+// replyData returns the ReplyData of a client response, its ReplyError
+// when the status is not ErrOk
+func replyData(response interface{}) (*ReplyData, error) {
+	var reply *ReplyData
+	switch r := response.(type) {
+	case *ReplyData:
+		reply = r
+	case ReplyData:
+		reply = &r
+	default:
+		return nil, fmt.Errorf("unexpected response type %T", response)
+	}
+	if reply == nil {
+		return nil, errors.New("nil response")
+	}
+	return reply, reply.Err()
+}
+
+// remarshal decode the generic JSON value src into a T, src is returned as
+// it is when it already is a T
+func remarshal[T any](src interface{}) (T, error) {
+	var v T
+	if src == nil {
+		return v, nil
+	}
+	if t, ok := src.(T); ok {
+		return t, nil
+	}
+	data, err := json.Marshal(src)
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// DecodeReplyData decode the data field of the ReplyData response returned
+// by a client endpoint into the caller type T. A non zero status returns a
+// *ReplyError.
+func DecodeReplyData[T any](response interface{}) (T, error) {
+	reply, err := replyData(response)
+	if err != nil {
+		var v T
+		return v, err
+	}
+	return remarshal[T](reply.Data)
+}
+
+// DecodeReplyRows decode the rows field of the ReplyData response returned
+// by a client endpoint into a slice of the caller type T, with the total.
+// A non zero status returns a *ReplyError.
+func DecodeReplyRows[T any](response interface{}) ([]T, int, error) {
+	reply, err := replyData(response)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := remarshal[[]T](reply.List)
+	return rows, reply.Total, err
+}
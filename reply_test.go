@@ -0,0 +1,102 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDefaultClassifier(t *testing.T) {
+	isFailure := defaultClassifier(
+		[]int{ErrException}, context.Canceled, ErrRateLimited)
+	var nilReply *ReplyData
+	exception := NewReplyData(ErrException)
+	for _, c := range []struct {
+		name     string
+		response interface{}
+		err      error
+		want     bool
+	}{
+		{"ok", NewReplyData(ErrOk), nil, false},
+		{"status", exception, nil, true},
+		{"value", *exception, nil, true},
+		{"nil pointer", nilReply, nil, false},
+		{"other status", NewReplyData(ErrTimeout), nil, false},
+		{"reply error", nil, exception.Err(), true},
+		{"transport", nil, errors.New("connection refused"), true},
+		{"canceled", nil, &url.Error{Err: context.Canceled}, false},
+		{"rate limited", nil, fmt.Errorf("call: %w", ErrRateLimited), false},
+	} {
+		if got := isFailure(c.response, c.err); got != c.want {
+			t.Errorf("%s: failure %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+type testUser struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// testDecodeResponse decode body like the client endpoints
+func testDecodeResponse(t *testing.T, body string) interface{} {
+	response, err := HTTPDecodeResponse(context.Background(),
+		&http.Response{Body: ioutil.NopCloser(strings.NewReader(body))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestDecodeReplyData(t *testing.T) {
+	response := testDecodeResponse(t,
+		`{"status":0,"data":{"id":1,"name":"a"}}`)
+	user, err := DecodeReplyData[testUser](response)
+	if err != nil || user != (testUser{1, "a"}) {
+		t.Errorf("DecodeReplyData = %+v, %v", user, err)
+	}
+	// the data of a server side ReplyData is returned as it is
+	row := &testUser{2, "b"}
+	if v, err := DecodeReplyData[*testUser](
+		RowReplyData(row)); err != nil || v != row {
+		t.Errorf("DecodeReplyData = %+v, %v, want the row", v, err)
+	}
+}
+
+func TestDecodeReplyRows(t *testing.T) {
+	response := testDecodeResponse(t,
+		`{"status":0,"total":5,"rows":[{"id":1},{"id":2}]}`)
+	users, total, err := DecodeReplyRows[testUser](response)
+	if err != nil || total != 5 || len(users) != 2 || users[1].ID != 2 {
+		t.Errorf("DecodeReplyRows = %+v, %d, %v", users, total, err)
+	}
+	users, total, err = DecodeReplyRows[testUser](
+		testDecodeResponse(t, `{"status":0}`))
+	if err != nil || total != 0 || users != nil {
+		t.Errorf("DecodeReplyRows of no rows = %+v, %d, %v",
+			users, total, err)
+	}
+}
+
+func TestDecodeReplyError(t *testing.T) {
+	response := testDecodeResponse(t,
+		`{"status":1007,"message":"not found","errors":{"id":"unknown"}}`)
+	_, err := DecodeReplyData[testUser](response)
+	var e *ReplyError
+	if !errors.As(err, &e) || e.Status != ErrDataNotFound ||
+		e.Message != "not found" || e.Errs["id"] != "unknown" {
+		t.Errorf("error %#v, want the ReplyError", err)
+	}
+	if _, _, err := DecodeReplyRows[testUser](response); !IsReplyStatus(
+		err, ErrDataNotFound) {
+		t.Errorf("DecodeReplyRows error %v, want status 1007", err)
+	}
+	if _, err := DecodeReplyData[testUser]("text"); err == nil {
+		t.Error("no error of an unexpected response")
+	}
+}
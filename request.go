@@ -4,17 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
 
 	"github.com/chuangxin1/httprouter"
-	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/chuangxin1/toolkit/binding"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/sd"
-	"github.com/go-kit/kit/sd/lb"
-	"github.com/sony/gobreaker"
+	"github.com/golang/protobuf/proto"
+	"github.com/ugorji/go/codec"
 
 	httptransport "github.com/go-kit/kit/transport/http"
 )
@@ -50,6 +55,14 @@ func routePath(ctx context.Context, req *http.Request) {
 	}
 }
 
+// clientRequestHeaders set the headers every outbound request carries
+func clientRequestHeaders(ctx context.Context, req *http.Request) {
+	setServiceToken(ctx, req)
+	setRequestID(ctx, req)
+	setRequestDeadline(ctx, req)
+	injectTrace(ctx, req)
+}
+
 // ClientEncodeGetRequest client get encode request
 func ClientEncodeGetRequest(
 	ctx context.Context,
@@ -61,25 +74,45 @@ func ClientEncodeGetRequest(
 		req.Header.Set(HTTPHeaderAuthorization, auth)
 	}
 	routePath(ctx, req)
+	setPathParams(req, request)
 	token, _ := ctx.Value(ContextKeyAccessToken).(string)
 	if token != "" {
 		values.Set(VarUserAuthorization, token)
 	}
 
+	clientRequestHeaders(ctx, req)
+
 	req.URL.RawQuery = values.Encode()
 	return nil
 }
 
-// ClientEncodeJSONRequest is an EncodeRequestFunc that serializes the request
-// as a JSON object to the Request body. Many JSON-over-HTTP services can use
-// it as a sensible default. If the request implements Headerer, the provided
-// headers will be applied to the request.
-func ClientEncodeJSONRequest(
+// clientEncodeBody prepare req like ClientEncodeJSONRequest and set body of
+// contentType as the request body
+func clientEncodeBody(
 	ctx context.Context,
 	req *http.Request,
-	request interface{}) error {
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request interface{},
+	contentType string,
+	body []byte) error {
+	clientPrepareRequest(ctx, req, request, contentType)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+// clientPrepareRequest set the content type, path, headers and access token
+// of a request with a body
+func clientPrepareRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{},
+	contentType string) {
+	req.Header.Set("Content-Type", contentType)
 	routePath(ctx, req)
+	setPathParams(req, request)
 	if headerer, ok := request.(httptransport.Headerer); ok {
 		for k := range headerer.Headers() {
 			req.Header.Set(k, headerer.Headers().Get(k))
@@ -94,10 +127,153 @@ func ClientEncodeJSONRequest(
 		values.Set(VarUserAuthorization, token)
 	}
 	req.URL.RawQuery = values.Encode()
+	clientRequestHeaders(ctx, req)
+}
 
+// ClientEncodeJSONRequest is an EncodeRequestFunc that serializes the request
+// as a JSON object to the Request body. Many JSON-over-HTTP services can use
+// it as a sensible default. If the request implements Headerer, the provided
+// headers will be applied to the request.
+func ClientEncodeJSONRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{}) error {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(request); err != nil {
+		return err
+	}
+	return clientEncodeBody(
+		ctx, req, request, "application/json; charset=utf-8", b.Bytes())
+}
+
+// ClientEncodeFormRequest is an EncodeRequestFunc that serializes the request
+// as an url encoded form, the form tags of the struct are the field names.
+// url.Values requests are sent as they are.
+func ClientEncodeFormRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{}) error {
+	values, ok := request.(url.Values)
+	if !ok {
+		values = URLValuesStruct(request)
+	}
+	return clientEncodeBody(
+		ctx, req, request, binding.MIMEPOSTForm, []byte(values.Encode()))
+}
+
+// ClientEncodeXMLRequest is an EncodeRequestFunc that serializes the request
+// as XML to the Request body
+func ClientEncodeXMLRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{}) error {
 	var b bytes.Buffer
-	req.Body = ioutil.NopCloser(&b)
-	return json.NewEncoder(&b).Encode(request)
+	if err := xml.NewEncoder(&b).Encode(request); err != nil {
+		return err
+	}
+	return clientEncodeBody(
+		ctx, req, request, "application/xml; charset=utf-8", b.Bytes())
+}
+
+// ClientEncodeMsgpackRequest is an EncodeRequestFunc that serializes the
+// request as msgpack to the Request body
+func ClientEncodeMsgpackRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{}) error {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, new(codec.MsgpackHandle)).Encode(request)
+	if err != nil {
+		return err
+	}
+	return clientEncodeBody(ctx, req, request, binding.MIMEMSGPACK, b)
+}
+
+// ClientEncodeProtobufRequest is an EncodeRequestFunc that serializes the
+// request, a proto.Message, as protobuf to the Request body
+func ClientEncodeProtobufRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{}) error {
+	msg, ok := request.(proto.Message)
+	if !ok {
+		return errors.New("protobuf: request is not a proto.Message")
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return clientEncodeBody(ctx, req, request, binding.MIMEPROTOBUF, b)
+}
+
+// setPathParams substitute the :name and *name segments of the url path by
+// the fields of request with the path tag of the same name
+func setPathParams(req *http.Request, request interface{}) {
+	if !strings.ContainsAny(req.URL.Path, ":*") {
+		return
+	}
+	v := reflect.ValueOf(request)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	params := map[string]string{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Tag.Get("path"); name != "" {
+			params[name] = fmt.Sprint(v.Field(i).Interface())
+		}
+	}
+
+	segments := strings.Split(req.URL.Path, "/")
+	raw := make([]string, len(segments))
+	for i, seg := range segments {
+		raw[i] = url.PathEscape(seg)
+		if len(seg) < 2 || seg[0] != ':' && seg[0] != '*' {
+			continue
+		}
+		if value, ok := params[seg[1:]]; ok {
+			segments[i] = value
+			if seg[0] == '*' {
+				raw[i] = (&url.URL{Path: value}).EscapedPath()
+			} else {
+				raw[i] = url.PathEscape(value)
+			}
+		}
+	}
+	req.URL.Path = strings.Join(segments, "/")
+	req.URL.RawPath = strings.Join(raw, "/")
+}
+
+// clientEncoder returns the request encoder of the client, JSON for POST,
+// PUT and PATCH and the query string otherwise by default
+func clientEncoder(
+	method string,
+	o *clientOptions) httptransport.EncodeRequestFunc {
+	if o.encoder != nil {
+		return o.encoder
+	}
+	switch o.contentType {
+	case binding.MIMEJSON:
+		return ClientEncodeJSONRequest
+	case binding.MIMEXML, binding.MIMEXML2:
+		return ClientEncodeXMLRequest
+	case binding.MIMEPOSTForm:
+		return ClientEncodeFormRequest
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		return ClientEncodeMsgpackRequest
+	case binding.MIMEPROTOBUF:
+		return ClientEncodeProtobufRequest
+	case binding.MIMEMultipartPOSTForm:
+		return ClientEncodeMultipartRequest
+	}
+	switch method {
+	case "POST", "PUT", "PATCH":
+		return ClientEncodeJSONRequest
+	}
+	return ClientEncodeGetRequest
 }
 
 // ClientRequestEndpoint client request Endpoint
@@ -105,34 +281,65 @@ func ClientRequestEndpoint(
 	ctx context.Context,
 	u *url.URL,
 	method, router string,
-	dec DecodeResponseFunc) endpoint.Endpoint {
-	var e endpoint.Endpoint
-	options := []httptransport.ClientOption{}
-	var enc httptransport.EncodeRequestFunc
+	dec DecodeResponseFunc,
+	opts ...ClientOption) endpoint.Endpoint {
+	e, _ := clientRequestEndpoint(ctx, u, method, router, dec, opts...)
+	return e
+}
 
-	switch method {
-	case "POST":
-		enc = ClientEncodeJSONRequest
-	default:
-		enc = ClientEncodeGetRequest
+// clientRequestEndpoint client request Endpoint and the closer removing its
+// circuit breaker from the registry
+func clientRequestEndpoint(
+	ctx context.Context,
+	u *url.URL,
+	method, router string,
+	dec DecodeResponseFunc,
+	opts ...ClientOption) (endpoint.Endpoint, io.Closer) {
+	var e endpoint.Endpoint
+	o := newClientOptions(opts)
+	options := []httptransport.ClientOption{
+		httptransport.ClientBefore(recordClientRequest),
+		httptransport.ClientAfter(recordClientResponse)}
+	if len(o.header) > 0 {
+		options = append(
+			options, httptransport.ClientBefore(setClientHeader(o.header)))
 	}
+	if len(o.before) > 0 {
+		options = append(options, httptransport.ClientBefore(o.before...))
+	}
+	if len(o.after) > 0 {
+		options = append(options, httptransport.ClientAfter(o.after...))
+	}
+	target := o.serviceName(u.Host)
+	options = append(
+		options, httptransport.SetClient(clientDoer{target, o}))
+
 	e = httptransport.NewClient(
 		method,
 		CopyURL(u, router),
-		enc,
+		clientEncoder(method, o),
 		httptransport.DecodeResponseFunc(dec),
 		options...,
 	).Endpoint()
 
-	e = circuitbreaker.Gobreaker(
-		gobreaker.NewCircuitBreaker(gobreaker.Settings{}))(e)
-	//e = ratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(time.Second), qps))(e)
+	cfg := breakerConfig(target, o)
+	cb := newBreaker(u.Host+" "+method+" "+router, target, cfg)
+	e = breakerMiddleware(cb, cfg.IsFailure)(e)
+	e = clientTracingMiddleware(target, method+" "+router)(e)
+	e = clientMetricsMiddleware(target, method+" "+router)(e)
+	e = clientRateLimit(e, o, u.Host, method, router)
+	if name := o.serviceName(""); name != "" {
+		e = clientTargetMiddleware(name)(e)
+	}
 
-	return e
+	return e, cb
 }
 
-// ClientLoadBalancer load balance
-func ClientLoadBalancer(endpoints sd.FixedEndpointer) endpoint.Endpoint {
-	balancer := lb.NewRoundRobin(endpoints)
-	return lb.Retry(retryMax, retryTimeout, balancer)
+// ClientLoadBalancer load balance, the retries follow the ClientRetry policy
+func ClientLoadBalancer(
+	endpoints sd.FixedEndpointer,
+	opts ...ClientOption) endpoint.Endpoint {
+	o := newClientOptions(opts)
+	return clientBalancer(
+		fixedInstances(endpoints, newOutlierDetector(o)), o)
 }
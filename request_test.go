@@ -0,0 +1,89 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testLevel struct {
+	high bool
+}
+
+func (l testLevel) String() string {
+	if l.high {
+		return "high"
+	}
+	return "low"
+}
+
+type testQuery struct {
+	ID       int64     `form:"id" path:"id"`
+	Name     string    `form:"name"`
+	Count    uint      `form:"count"`
+	Small    uint8     `form:"small"`
+	Ratio    float32   `form:"ratio"`
+	Page     *int      `form:"page"`
+	Size     *int      `form:"size"`
+	Tags     []string  `form:"tag"`
+	Since    time.Time `form:"since"`
+	Level    testLevel `form:"level"`
+	Raw      []byte    `form:"raw"`
+	Nested   struct{}  `form:"nested"`
+	Skipped  string    `form:"-"`
+	Untagged string
+	hidden   string     `form:"hidden"`
+	Path     string     `path:"file"`
+	Query    url.Values `form:"q"`
+}
+
+func TestURLValuesStruct(t *testing.T) {
+	page := 2
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	q := testQuery{
+		ID: 1 << 40, Name: "a b", Count: 7, Small: 8, Ratio: 0.1,
+		Page: &page, Tags: []string{"x", "y"}, Since: since,
+		Level: testLevel{true}, Raw: []byte("raw"), Skipped: "s",
+		Untagged: "u", hidden: "h", Path: "p"}
+
+	want := url.Values{
+		"name":  {"a b"},
+		"count": {"7"},
+		"small": {"8"},
+		"ratio": {"0.1"},
+		"page":  {"2"},
+		"tag":   {"x", "y"},
+		"since": {"2026-01-02T03:04:05Z"},
+		"level": {"high"},
+		"raw":   {"raw"},
+	}
+	for _, request := range []interface{}{q, &q} {
+		if got := URLValuesStruct(request); !reflect.DeepEqual(got, want) {
+			t.Errorf("URLValuesStruct(%T) = %v, want %v", request, got, want)
+		}
+	}
+	if got := URLValuesStruct(nil); len(got) != 0 {
+		t.Errorf("URLValuesStruct(nil) = %v, want empty", got)
+	}
+}
+
+func TestClientEncodeGetRequest(t *testing.T) {
+	q := &testQuery{ID: 42, Name: "x&y", Path: "a b/c.txt"}
+	req, err := http.NewRequest("GET", "http://svc/items/:id/*file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ClientEncodeGetRequest(context.Background(), req, q); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.URL.EscapedPath(), "/items/42/a%20b/c.txt"; got != want {
+		t.Errorf("path %q, want %q", got, want)
+	}
+	values := req.URL.Query()
+	if values.Get("name") != "x&y" || values.Get("id") != "" {
+		t.Errorf("query %q, want name only", req.URL.RawQuery)
+	}
+}
@@ -0,0 +1,98 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+const (
+	// HTTPHeaderRequestID HTTP header X-Request-Id
+	HTTPHeaderRequestID = `X-Request-Id`
+
+	// max accepted length of an incoming request id
+	maxRequestIDLength = 128
+)
+
+// NewRequestID new UUIDv7 request id, ordered by creation time
+func NewRequestID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint64(b[:8], ms<<16)
+	rand.Read(b[6:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// requestID returns the incoming request id, or a new one when it is
+// missing or malformed
+func requestID(id string) string {
+	if id == "" || len(id) > maxRequestIDLength {
+		return NewRequestID()
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return NewRequestID()
+		}
+	}
+	return id
+}
+
+// ContextRequestID returns the request id of the context
+func ContextRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ContextKeyRequestXRequestID).(string)
+	return id
+}
+
+// ContextLogger returns logger with the request id of ctx
+func ContextLogger(ctx context.Context, logger log.Logger) log.Logger {
+	if id := ContextRequestID(ctx); id != "" {
+		return log.With(logger, "request_id", id)
+	}
+	return logger
+}
+
+// WriteRequestID is a ServerResponseFunc that echoes the request id
+func WriteRequestID(
+	ctx context.Context,
+	w http.ResponseWriter) context.Context {
+	if id := ContextRequestID(ctx); id != "" {
+		w.Header().Set(HTTPHeaderRequestID, id)
+	}
+	return ctx
+}
+
+// setRequestID forward the request id to the called service
+func setRequestID(ctx context.Context, req *http.Request) {
+	if id := ContextRequestID(ctx); id != "" {
+		req.Header.Set(HTTPHeaderRequestID, id)
+	}
+}
+
+// RequestIDMiddleware ensures every request carries a request id and echoes
+// it in the response, for handlers not served through endpoints
+func RequestIDMiddleware() HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := requestID(r.Header.Get(HTTPHeaderRequestID))
+			r.Header.Set(HTTPHeaderRequestID, id)
+			w.Header().Set(HTTPHeaderRequestID, id)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestIDErrorHandler log transport errors with the request id
+type requestIDErrorHandler struct {
+	logger log.Logger
+}
+
+func (h requestIDErrorHandler) Handle(ctx context.Context, err error) {
+	ContextLogger(ctx, h.logger).Log("err", err)
+}
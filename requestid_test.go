@@ -0,0 +1,98 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+var uuidv7 = regexp.MustCompile(
+	`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewRequestID(t *testing.T) {
+	first := NewRequestID()
+	time.Sleep(2 * time.Millisecond)
+	second := NewRequestID()
+	if !uuidv7.MatchString(first) || !uuidv7.MatchString(second) {
+		t.Fatalf("request ids %s and %s, want UUIDv7", first, second)
+	}
+	if first >= second {
+		t.Errorf("request id %s not after %s", second, first)
+	}
+}
+
+func TestRequestIDValidation(t *testing.T) {
+	for id, kept := range map[string]bool{
+		"abc-123":                true,
+		"":                       false,
+		"a b":                    false,
+		"a\nb":                   false,
+		"é":                      false,
+		strings.Repeat("a", 128): true,
+		strings.Repeat("a", 129): false,
+	} {
+		got := requestID(id)
+		if kept && got != id || !kept && !uuidv7.MatchString(got) {
+			t.Errorf("requestID(%.20q) = %s", id, got)
+		}
+	}
+}
+
+func TestRequestIDForwarded(t *testing.T) {
+	var forwarded string
+	downstream := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r.Header.Get(HTTPHeaderRequestID)
+			HTTPWriteJSON(w, NewReplyData(ErrOk))
+		}))
+	defer downstream.Close()
+	u, err := url.Parse(downstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := ClientRequestEndpoint(
+		context.Background(), u, "GET", "/", HTTPDecodeResponse)
+	h := NewHTTPTansportServer(false, call,
+		func(context.Context, *http.Request) (interface{}, error) {
+			return struct{}{}, nil
+		},
+		HTTPWriteCtxJSON, log.NewNopLogger())
+
+	for _, incoming := range []string{"abc-123", "", "a b"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if incoming != "" {
+			r.Header.Set(HTTPHeaderRequestID, incoming)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		id := w.Header().Get(HTTPHeaderRequestID)
+		if incoming == "abc-123" && id != incoming ||
+			incoming != "abc-123" && !uuidv7.MatchString(id) {
+			t.Errorf("request id %q of %q", id, incoming)
+		}
+		if forwarded != id {
+			t.Errorf("forwarded request id %q, want %q", forwarded, id)
+		}
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	h := RequestIDMiddleware()(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			seen = r.Header.Get(HTTPHeaderRequestID)
+		}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	id := w.Header().Get(HTTPHeaderRequestID)
+	if !uuidv7.MatchString(id) || seen != id {
+		t.Errorf("request id %q seen as %q, want a new one", id, seen)
+	}
+}
@@ -0,0 +1,418 @@
+package toolkit
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// ResponseStore storage of cached responses
+type ResponseStore interface {
+	// Get returns the value of key
+	Get(key string) ([]byte, bool)
+	// Set store value of key for ttl, the key is invalidated with any of
+	// tags
+	Set(key string, value []byte, ttl time.Duration, tags []string)
+	// Invalidate delete the keys of tags
+	Invalidate(tags ...string)
+}
+
+// redisResponseStore ResponseStore in redis, tags are redis sets of keys
+type redisResponseStore struct {
+	cache  *RedisCache
+	prefix string
+}
+
+// NewRedisResponseStore new ResponseStore in redis, prefix namespaces the
+// redis keys
+func NewRedisResponseStore(cache *RedisCache, prefix string) ResponseStore {
+	return &redisResponseStore{cache: cache, prefix: prefix}
+}
+
+func (s *redisResponseStore) Get(key string) ([]byte, bool) {
+	data, err := s.cache.client().Get(s.prefix + ":" + key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *redisResponseStore) Set(
+	key string,
+	value []byte,
+	ttl time.Duration,
+	tags []string) {
+	c := s.cache.client()
+	key = s.prefix + ":" + key
+	c.Set(key, value, ttl)
+	for _, tag := range tags {
+		tagKey := s.prefix + ":tag:" + tag
+		c.SAdd(tagKey, key)
+		c.Expire(tagKey, ttl)
+	}
+}
+
+func (s *redisResponseStore) Invalidate(tags ...string) {
+	c := s.cache.client()
+	for _, tag := range tags {
+		tagKey := s.prefix + ":tag:" + tag
+		keys, _ := c.SMembers(tagKey).Result()
+		// one by one, the keys may live in different cluster slots
+		for _, key := range keys {
+			c.Del(key)
+		}
+		c.Del(tagKey)
+	}
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+	tags    []string
+}
+
+// lruResponseStore in process ResponseStore evicting the least recently
+// used responses
+type lruResponseStore struct {
+	lock  sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]bool
+}
+
+// NewLRUResponseStore new in process ResponseStore of at most size entries
+func NewLRUResponseStore(size int) ResponseStore {
+	if size <= 0 {
+		size = 1000
+	}
+	return &lruResponseStore{
+		size:  size,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+		tags:  map[string]map[string]bool{}}
+}
+
+func (s *lruResponseStore) Get(key string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		s.remove(el)
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+func (s *lruResponseStore) Set(
+	key string,
+	value []byte,
+	ttl time.Duration,
+	tags []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	entry := &lruEntry{
+		key:     key,
+		value:   value,
+		expires: time.Now().Add(ttl),
+		tags:    tags}
+	s.items[key] = s.ll.PushFront(entry)
+	for _, tag := range tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = map[string]bool{}
+		}
+		s.tags[tag][key] = true
+	}
+	for s.ll.Len() > s.size {
+		s.remove(s.ll.Back())
+	}
+}
+
+func (s *lruResponseStore) Invalidate(tags ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if el, ok := s.items[key]; ok {
+				s.remove(el)
+			}
+		}
+		delete(s.tags, tag)
+	}
+}
+
+func (s *lruResponseStore) remove(el *list.Element) {
+	entry := el.Value.(*lruEntry)
+	s.ll.Remove(el)
+	delete(s.items, entry.key)
+	for _, tag := range entry.tags {
+		delete(s.tags[tag], entry.key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+// ResponseCacheConfig response cache config
+type ResponseCacheConfig struct {
+	// TTL how long responses are cached, default 1 minute
+	TTL time.Duration
+	// Vary request headers the response depends on
+	Vary []string
+	// Shared caches the responses of the authenticated requests once for
+	// all the callers, only for responses not depending on the caller. By
+	// default they are cached per user or calling service.
+	Shared bool
+	// Tags returns the invalidation tags of the request
+	Tags func(r *http.Request) []string
+	// MaxAge Cache-Control max-age sent to clients, zero makes clients
+	// revalidate every time
+	MaxAge time.Duration
+}
+
+// cachedResponse stored response
+type cachedResponse struct {
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	ETag     string      `json:"etag"`
+	Modified int64       `json:"modified"`
+}
+
+// bufferWriter buffers the response
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// responseCaller returns the verified caller of r, empty for anonymous
+// requests, ok is false when the credentials are not valid
+func responseCaller(r *http.Request) (caller string, ok bool) {
+	ctx := PopulateRequestContext(r.Context(), r)
+	if token, _ := ctx.Value(ContextKeyAccessToken).(string); token != "" {
+		// verified like AuthMiddleware
+		tok, err := ParseAccessToken(token)
+		if err != nil {
+			return "", false
+		}
+		ctoken, err := checkAuth(tok)
+		if err != nil {
+			return "", false
+		}
+		return "user:" + strconv.Itoa(ctoken.ID), true
+	}
+	token, _ := ctx.Value(ContextKeyRequestServiceToken).(string)
+	if token != "" {
+		tok, err := ParseServiceToken(token)
+		if err != nil {
+			return "", false
+		}
+		return "service:" + tok.Service, true
+	}
+	return "", true
+}
+
+// responseCacheKey key of the request, its vary headers and caller
+func responseCacheKey(
+	r *http.Request,
+	caller string,
+	cfg ResponseCacheConfig) string {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.URL.Path)
+	for _, k := range keys {
+		b.WriteString("\n" + k + "=" + strings.Join(query[k], ","))
+	}
+	for _, h := range cfg.Vary {
+		b.WriteString("\n" + h + ":" + r.Header.Get(h))
+	}
+	if caller != "" {
+		// the anonymous requests never share the authenticated responses
+		if cfg.Shared {
+			caller = "authenticated"
+		}
+		b.WriteString("\ncaller:" + caller)
+	}
+	return SHA1(b.String())
+}
+
+// notModified reports whether the conditional request matches the response
+func notModified(r *http.Request, res cachedResponse) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == res.ETag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && res.Modified <= t.Unix()
+	}
+	return false
+}
+
+func writeCachedResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	res cachedResponse,
+	private bool,
+	cfg ResponseCacheConfig) {
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("ETag", res.ETag)
+	w.Header().Set(
+		"Last-Modified",
+		time.Unix(res.Modified, 0).UTC().Format(http.TimeFormat))
+	control := "no-cache"
+	if cfg.MaxAge > 0 {
+		control = "max-age=" + strconv.Itoa(durationSeconds(cfg.MaxAge))
+	}
+	if private {
+		// not stored by shared caches on the way
+		control = "private, " + control
+	}
+	w.Header().Set("Cache-Control", control)
+	if len(cfg.Vary) > 0 {
+		w.Header().Set("Vary", strings.Join(cfg.Vary, ", "))
+	}
+	if notModified(r, res) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "HEAD" {
+		w.Write(res.Body)
+	}
+}
+
+// ResponseCacheMiddleware cache successful GET responses in store, answer
+// them with ETag and Last-Modified and conditional requests with 304. The
+// credentials of the caller are verified before the lookup, the requests
+// with invalid ones are left to the endpoint and never cached.
+func ResponseCacheMiddleware(
+	store ResponseStore,
+	cfg ResponseCacheConfig) HTTPMiddleware {
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Minute
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" && r.Method != "HEAD" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			caller, ok := responseCaller(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := responseCacheKey(r, caller, cfg)
+			if data, ok := store.Get(key); ok {
+				var res cachedResponse
+				if json.Unmarshal(data, &res) == nil {
+					writeCachedResponse(w, r, res, caller != "", cfg)
+					return
+				}
+			}
+
+			bw := &bufferWriter{header: http.Header{}}
+			next.ServeHTTP(bw, r)
+
+			var reply ReplyData
+			body := bw.body.Bytes()
+			cacheable := bw.status == http.StatusOK &&
+				(json.Unmarshal(body, &reply) != nil || reply.Status == ErrOk)
+			if !cacheable {
+				for k, v := range bw.header {
+					w.Header()[k] = v
+				}
+				if bw.status == 0 {
+					bw.status = http.StatusOK
+				}
+				w.WriteHeader(bw.status)
+				w.Write(body)
+				return
+			}
+
+			// per request headers are not replayed
+			bw.header.Del(HTTPHeaderRequestID)
+			bw.header.Del("Set-Cookie")
+			res := cachedResponse{
+				Header:   bw.header,
+				Body:     body,
+				ETag:     `"` + SHA1(string(body)) + `"`,
+				Modified: time.Now().Unix()}
+			if data, err := json.Marshal(res); err == nil {
+				var tags []string
+				if cfg.Tags != nil {
+					tags = cfg.Tags(r)
+				}
+				store.Set(key, data, cfg.TTL, tags)
+			}
+			writeCachedResponse(w, r, res, caller != "", cfg)
+		})
+	}
+}
+
+// InvalidateResponseCacheMiddleware invalidate the cached responses of the
+// tags returned by tags once the write endpoint succeeded
+func InvalidateResponseCacheMiddleware(
+	store ResponseStore,
+	tags func(ctx context.Context, request interface{}) []string,
+) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (response interface{}, err error) {
+			response, err = next(ctx, request)
+			if replyStatus(response, err) == strconv.Itoa(ErrOk) {
+				store.Invalidate(tags(ctx, request)...)
+			}
+			return
+		}
+	}
+}
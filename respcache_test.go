@@ -0,0 +1,117 @@
+package toolkit
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testUserToken access token of user id accepted by AuthMiddleware
+func testUserToken(t *testing.T, id int) string {
+	uid, err := NewAesCrypto().Encrypt([]byte(strconv.Itoa(id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AccessTokenStorageCache(strconv.Itoa(id), CacheAccessToken{
+		ID: id, Expires: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := NewAccessToken(AccessToken{
+		ID: base64.StdEncoding.EncodeToString(uid)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func testAuthConfig(t *testing.T) {
+	_, mr := testRedis(t)
+	SetRedisConfig(RedisConfig{Addr: mr.Addr()})
+	SetAesCryptoKey("0123456789abcdef")
+	SetAccessTokenKey("respcache_test")
+	t.Cleanup(func() {
+		SetRedisConfig(RedisConfig{})
+		SetAesCryptoKey("")
+		SetAccessTokenKey("")
+	})
+}
+
+// testCachedHandler replies the number of the request reaching it
+func testCachedHandler(cfg ResponseCacheConfig) http.Handler {
+	var runs int32
+	return ResponseCacheMiddleware(NewLRUResponseStore(10), cfg)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&runs, 1)
+			HTTPWriteJSON(w, &ReplyData{Status: ErrOk, Data: n})
+		}))
+}
+
+func testCachedGet(h http.Handler, token string) string {
+	r := httptest.NewRequest("GET", "/profile", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Body.String()
+}
+
+func TestResponseCachePerCaller(t *testing.T) {
+	testAuthConfig(t)
+	alice, bob := testUserToken(t, 1), testUserToken(t, 2)
+	h := testCachedHandler(ResponseCacheConfig{})
+
+	first := testCachedGet(h, alice)
+	if got := testCachedGet(h, alice); got != first {
+		t.Errorf("repeat of the caller %s, want cached %s", got, first)
+	}
+	if got := testCachedGet(h, bob); got == first {
+		t.Error("response of a user served to another one")
+	}
+	if got := testCachedGet(h, ""); got == first {
+		t.Error("authenticated response served to an anonymous request")
+	}
+	forged := alice[:len(alice)-2] + "xx"
+	invalid := testCachedGet(h, forged)
+	if invalid == first || testCachedGet(h, forged) == invalid {
+		t.Error("cache used by invalid credentials")
+	}
+}
+
+func TestResponseCacheShared(t *testing.T) {
+	testAuthConfig(t)
+	alice, bob := testUserToken(t, 1), testUserToken(t, 2)
+	h := testCachedHandler(ResponseCacheConfig{Shared: true})
+
+	first := testCachedGet(h, alice)
+	if got := testCachedGet(h, bob); got != first {
+		t.Errorf("shared response %s, want %s", got, first)
+	}
+	if got := testCachedGet(h, ""); got == first {
+		t.Error("authenticated response served to an anonymous request")
+	}
+}
+
+func TestResponseCacheConditional(t *testing.T) {
+	h := testCachedHandler(ResponseCacheConfig{})
+	r := httptest.NewRequest("GET", "/items", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	r = httptest.NewRequest("GET", "/items", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("status %d, want 304 without body", w.Code)
+	}
+}
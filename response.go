@@ -33,12 +33,25 @@ const (
 	ErrDataExists = 1009
 	// ErrDataValidate 403 数据验证错误
 	ErrDataValidate = 1010
+	// ErrTooManyRequests 429 请求过于频繁
+	ErrTooManyRequests = 1011
+	// ErrTimeout 504 请求超时
+	ErrTimeout = 1012
+	// ErrServiceUnavailable 503 服务繁忙
+	ErrServiceUnavailable = 1013
+	// ErrIdempotencyKeyReused 422 幂等键已用于其他请求
+	ErrIdempotencyKeyReused = 1014
+	// ErrRequestInProgress 409 相同请求正在处理
+	ErrRequestInProgress = 1015
 
 	// VarUserAuthorization 传递用户验证信息
 	VarUserAuthorization = `access_token`
 
 	// HTTPHeaderAuthorization HTTP header Authorization
 	HTTPHeaderAuthorization = `Authorization`
+
+	// HTTPHeaderAPIKey HTTP header X-Api-Key
+	HTTPHeaderAPIKey = `X-Api-Key`
 )
 
 var (
@@ -68,6 +81,11 @@ func init() {
 	statusMessage[ErrNotAllowed] = `No access`
 	statusMessage[ErrDataExists] = `Data exists`
 	statusMessage[ErrDataValidate] = `Data verification failed`
+	statusMessage[ErrTooManyRequests] = `Too many requests`
+	statusMessage[ErrTimeout] = `Request timeout`
+	statusMessage[ErrServiceUnavailable] = `Service unavailable`
+	statusMessage[ErrIdempotencyKeyReused] = `Idempotency key reused`
+	statusMessage[ErrRequestInProgress] = `Request in progress`
 }
 
 // NewReplyData creates and return ReplyData with status and message
@@ -138,9 +156,20 @@ func RowReplyData(row interface{}) *ReplyData {
 	}
 }
 
+// powerHeader value of the X-Power response header, empty drops it
+var powerHeader = "csacred/0.2.0"
+
+// SetPowerHeader set the X-Power response header, an empty value drops the
+// header. Call it before serving requests.
+func SetPowerHeader(value string) {
+	powerHeader = value
+}
+
 func header(w http.ResponseWriter, contentType string) {
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("X-Power", "csacred/0.2.0")
+	if powerHeader != "" {
+		w.Header().Set("X-Power", powerHeader)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -188,16 +217,19 @@ func HTTPWriteCtxBytes(
 }
 
 // HTTPEncodeError request encode error response
-func HTTPEncodeError(_ context.Context, err error, w http.ResponseWriter) {
+func HTTPEncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	WriteRequestID(ctx, w)
 	HTTPWriteJSON(w, ErrReplyData(ErrParamsError, err.Error()))
 }
 
 // HTTPEncodeXMLError request encode error response
-func HTTPEncodeXMLError(_ context.Context, err error, w http.ResponseWriter) {
+func HTTPEncodeXMLError(ctx context.Context, err error, w http.ResponseWriter) {
+	WriteRequestID(ctx, w)
 	HTTPWriteXML(w, ErrReplyData(ErrParamsError, err.Error()))
 }
 
-// HTTPDecodeResponse decode client
+// HTTPDecodeResponse decode client, the data and rows are decoded into the
+// caller types by DecodeReplyData and DecodeReplyRows
 func HTTPDecodeResponse(
 	ctx context.Context,
 	r *http.Response) (interface{}, error) {
@@ -256,13 +288,17 @@ func PopulateRequestContext(
 		ContextKeyRequestHost:            r.Host,
 		ContextKeyRequestRemoteAddr:      r.RemoteAddr,
 		ContextKeyRequestXForwardedFor:   r.Header.Get("X-Forwarded-For"),
+		ContextKeyRequestClientIP:        ClientIP(r),
 		ContextKeyRequestXForwardedProto: r.Header.Get("X-Forwarded-Proto"),
 		ContextKeyRequestAuthorization:   token,
 		ContextKeyRequestReferer:         r.Header.Get("Referer"),
 		ContextKeyRequestUserAgent:       r.Header.Get("User-Agent"),
-		ContextKeyRequestXRequestID:      r.Header.Get("X-Request-Id"),
+		ContextKeyRequestXRequestID:      requestID(r.Header.Get(HTTPHeaderRequestID)),
 		ContextKeyRequestAccept:          r.Header.Get("Accept"),
 		ContextKeyAccessToken:            accessToken,
+		ContextKeyRequestServiceToken:    r.Header.Get(HTTPHeaderServiceToken),
+		ContextKeyRequestAPIKey:          r.Header.Get(HTTPHeaderAPIKey),
+		ContextKeyRequestDeadline:        requestDeadline(r.Header.Get(HTTPHeaderRequestTimeout)),
 	} {
 		//fmt.Println(k, v)
 		ctx = context.WithValue(ctx, k, v)
@@ -325,7 +361,8 @@ const (
 	ContextKeyRequestUserAgent
 
 	// ContextKeyRequestXRequestID is populated in the context by
-	// PopulateRequestContext. Its value is r.Header.Get("X-Request-Id").
+	// PopulateRequestContext. Its value is r.Header.Get("X-Request-Id"), or a
+	// new request id when the header is missing.
 	ContextKeyRequestXRequestID
 
 	// ContextKeyRequestAccept is populated in the context by
@@ -343,4 +380,64 @@ const (
 
 	// ContextKeyAccessToken auth access token
 	ContextKeyAccessToken
+
+	// ContextKeyRequestServiceToken is populated in the context by
+	// PopulateRequestContext. Its value is r.Header.Get("X-Service-Token").
+	ContextKeyRequestServiceToken
+
+	// ContextKeyClientTarget is populated in the context of client endpoints
+	// created with ClientTarget. Its value is the called service name.
+	ContextKeyClientTarget
+
+	// ContextKeyRequestAPIKey is populated in the context by
+	// PopulateRequestContext. Its value is r.Header.Get("X-Api-Key").
+	ContextKeyRequestAPIKey
+
+	// ContextKeyRequestDeadline is populated in the context by
+	// PopulateRequestContext. Its value is the local deadline in unix
+	// milliseconds of r.Header.Get("X-Request-Timeout").
+	ContextKeyRequestDeadline
+
+	// ContextKeyReplyHeaders is populated in the context by
+	// PopulateReplyHeaders. Its value is of type http.Header, the headers are
+	// written to the response by WriteReplyHeaders.
+	ContextKeyReplyHeaders
+
+	// ContextKeyCSPNonce is populated in the context by
+	// SecurityHeadersMiddleware. Its value is the Content-Security-Policy
+	// nonce of the request.
+	ContextKeyCSPNonce
+
+	// ContextKeyRequestClientIP is populated in the context by
+	// PopulateRequestContext. Its value is ClientIP(r).
+	ContextKeyRequestClientIP
 )
+
+// PopulateReplyHeaders is a RequestFunc that stores an empty http.Header in
+// the context, endpoints and middlewares add response headers to it with
+// SetReplyHeader.
+func PopulateReplyHeaders(
+	ctx context.Context,
+	_ *http.Request) context.Context {
+	return context.WithValue(ctx, ContextKeyReplyHeaders, http.Header{})
+}
+
+// WriteReplyHeaders is a ServerResponseFunc that writes the headers set by
+// SetReplyHeader to the response.
+func WriteReplyHeaders(
+	ctx context.Context,
+	w http.ResponseWriter) context.Context {
+	if h, ok := ctx.Value(ContextKeyReplyHeaders).(http.Header); ok {
+		for k, v := range h {
+			w.Header()[k] = v
+		}
+	}
+	return ctx
+}
+
+// SetReplyHeader set response header from an endpoint
+func SetReplyHeader(ctx context.Context, key, value string) {
+	if h, ok := ctx.Value(ContextKeyReplyHeaders).(http.Header); ok {
+		h.Set(key, value)
+	}
+}
@@ -0,0 +1,317 @@
+package toolkit
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryPolicy retry policy of the load balanced client endpoints
+type RetryPolicy struct {
+	// Max attempts including the first one, default 3
+	Max int
+	// Timeout of all the attempts, default 500ms
+	Timeout time.Duration
+	// BaseDelay backoff before the first retry, doubled on every retry,
+	// default 10ms
+	BaseDelay time.Duration
+	// MaxDelay upper bound of the backoff, default 1s
+	MaxDelay time.Duration
+	// NonIdempotent retries POST and PATCH requests too
+	NonIdempotent bool
+	// HTTPStatuses HTTP statuses retried, default 429, 502, 503 and 504
+	HTTPStatuses []int
+	// ReplyStatuses ReplyData statuses retried, default ErrTooManyRequests,
+	// ErrTimeout and ErrServiceUnavailable
+	ReplyStatuses []int
+	// IsRetryable classifies the attempts, status is the HTTP status or 0
+	// when no response was received. Default HTTPStatuses, ReplyStatuses
+	// and the errors of calls not canceled, expired or rate limited.
+	IsRetryable func(response interface{}, err error, status int) bool
+	// Budget limits the retries of the client, nil is unlimited
+	Budget *RetryBudget
+}
+
+// RetryBudget caps the retries to a ratio of the requests, so retries do
+// not multiply the load of a failing service
+type RetryBudget struct {
+	lock     sync.Mutex
+	ratio    float64
+	min      int
+	buckets  [10]retryBucket
+	lastTick int64
+}
+
+type retryBucket struct {
+	requests int
+	retries  int
+}
+
+// NewRetryBudget new RetryBudget allowing ratio retries per request over
+// the last 10 seconds, plus minPerSecond retries per second
+func NewRetryBudget(ratio float64, minPerSecond int) *RetryBudget {
+	return &RetryBudget{ratio: ratio, min: minPerSecond}
+}
+
+// bucket returns the bucket of the current second, clearing the expired ones
+func (b *RetryBudget) bucket() *retryBucket {
+	now := time.Now().Unix()
+	if gap := now - b.lastTick; gap > 0 {
+		if gap > int64(len(b.buckets)) {
+			gap = int64(len(b.buckets))
+		}
+		for i := int64(1); i <= gap; i++ {
+			b.buckets[(b.lastTick+i)%int64(len(b.buckets))] = retryBucket{}
+		}
+		b.lastTick = now
+	}
+	return &b.buckets[now%int64(len(b.buckets))]
+}
+
+func (b *RetryBudget) request() {
+	b.lock.Lock()
+	b.bucket().requests++
+	b.lock.Unlock()
+}
+
+// withdraw reports whether a retry is allowed and records it
+func (b *RetryBudget) withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	cur := b.bucket()
+	requests, retries := 0, 0
+	for _, bucket := range b.buckets {
+		requests += bucket.requests
+		retries += bucket.retries
+	}
+	if float64(retries) >=
+		b.ratio*float64(requests)+float64(b.min*len(b.buckets)) {
+		return false
+	}
+	cur.retries++
+	return true
+}
+
+// contextKeyClientCall carries the clientCall of a retried attempt
+const contextKeyClientCall contextStringKey = `client_call`
+
+// clientCall records the method and HTTP status of a client request
+type clientCall struct {
+	lock   sync.Mutex
+	method string
+	status int
+	// streamed the body is read once, the request can not be sent again
+	streamed bool
+}
+
+func (c *clientCall) get() (method string, status int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.method, c.status
+}
+
+// replayable reports whether the request may be sent again
+func (c *clientCall) replayable() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return !c.streamed
+}
+
+// markStreamed records that the request of ctx streams its body
+func markStreamed(ctx context.Context) {
+	if call, ok := ctx.Value(contextKeyClientCall).(*clientCall); ok {
+		call.lock.Lock()
+		call.streamed = true
+		call.lock.Unlock()
+	}
+}
+
+var retries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "toolkit",
+		Subsystem: "client",
+		Name:      "retries_total",
+		Help:      "Client retries by outcome, retried or budget exhausted.",
+	},
+	[]string{"outcome"})
+
+func init() {
+	prometheus.MustRegister(retries)
+}
+
+// ClientRetry sets the retry policy of FactoryLoadBalancer and
+// ClientLoadBalancer
+func ClientRetry(p RetryPolicy) ClientOption {
+	return func(o *clientOptions) { o.retry = &p }
+}
+
+// recordClientRequest ClientBefore recording the method of the request
+func recordClientRequest(
+	ctx context.Context,
+	req *http.Request) context.Context {
+	if call, ok := ctx.Value(contextKeyClientCall).(*clientCall); ok {
+		call.lock.Lock()
+		call.method = req.Method
+		call.lock.Unlock()
+	}
+	return ctx
+}
+
+// recordClientResponse ClientAfter recording the status of the response
+func recordClientResponse(
+	ctx context.Context,
+	res *http.Response) context.Context {
+	if call, ok := ctx.Value(contextKeyClientCall).(*clientCall); ok {
+		call.lock.Lock()
+		call.status = res.StatusCode
+		call.lock.Unlock()
+	}
+	return ctx
+}
+
+// retryPolicy returns the retry policy of the client with defaults
+func retryPolicy(o *clientOptions) RetryPolicy {
+	var p RetryPolicy
+	if o.retry != nil {
+		p = *o.retry
+	}
+	if p.Max <= 0 {
+		p.Max = retryMax
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = retryTimeout
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 10 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = time.Second
+	}
+	if p.HTTPStatuses == nil {
+		p.HTTPStatuses = []int{
+			http.StatusTooManyRequests, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	if p.ReplyStatuses == nil {
+		p.ReplyStatuses = []int{
+			ErrTooManyRequests, ErrTimeout, ErrServiceUnavailable}
+	}
+	if p.IsRetryable == nil {
+		httpStatuses := map[int]bool{}
+		for _, s := range p.HTTPStatuses {
+			httpStatuses[s] = true
+		}
+		retryable := defaultClassifier(
+			p.ReplyStatuses,
+			context.Canceled, context.DeadlineExceeded, ErrRateLimited)
+		p.IsRetryable = func(
+			response interface{},
+			err error,
+			status int) bool {
+			return httpStatuses[status] || retryable(response, err)
+		}
+	}
+	return p
+}
+
+// idempotent reports whether requests of method can be repeated safely
+func idempotent(method string) bool {
+	switch method {
+	case "POST", "PATCH":
+		return false
+	}
+	return true
+}
+
+// backoff returns the full jitter exponential delay of retry attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << uint(attempt)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// attemptResult result of a client request
+type attemptResult struct {
+	response interface{}
+	err      error
+	call     *clientCall
+	// hedged the result of a hedged request
+	hedged bool
+}
+
+// callAttempt call e recording the method and HTTP status of the request
+// in call
+func callAttempt(
+	ctx context.Context,
+	e endpoint.Endpoint,
+	request interface{},
+	call *clientCall) attemptResult {
+	response, err := e(
+		context.WithValue(ctx, contextKeyClientCall, call), request)
+	return attemptResult{response: response, err: err, call: call}
+}
+
+// retryEndpoint endpoint calling the endpoints picked by pk under the retry
+// policy p, hedged by h when not nil. The last attempt is returned.
+func retryEndpoint(
+	pk picker,
+	p RetryPolicy,
+	h *hedger) endpoint.Endpoint {
+	return func(
+		ctx context.Context,
+		request interface{}) (response interface{}, err error) {
+		ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+		if p.Budget != nil {
+			p.Budget.request()
+		}
+
+		picks := 0
+		next := func() (*lbInstance, error) {
+			picks++
+			return pk.pick(ctx, request, picks-1)
+		}
+		for attempt := 0; ; attempt++ {
+			inst, lbErr := next()
+			if lbErr != nil {
+				if attempt == 0 {
+					return nil, lbErr
+				}
+				return
+			}
+			var r attemptResult
+			if h != nil {
+				r = h.do(ctx, inst, next, request, p.IsRetryable)
+			} else {
+				r = callAttempt(ctx, inst.endpoint, request, &clientCall{})
+			}
+			response, err = r.response, r.err
+			method, status := r.call.get()
+			if attempt+1 >= p.Max || !r.call.replayable() ||
+				!p.IsRetryable(response, err, status) ||
+				!p.NonIdempotent && !idempotent(method) {
+				return
+			}
+			if p.Budget != nil && !p.Budget.withdraw() {
+				retries.WithLabelValues("budget_exhausted").Inc()
+				return
+			}
+			retries.WithLabelValues("retried").Inc()
+
+			t := time.NewTimer(p.backoff(attempt))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// testServer counts its requests and replies with the statuses in turn,
+// the last one once they are used
+func testServer(t *testing.T, statuses ...int) (*url.URL, *int32) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			n := int(atomic.AddInt32(&hits, 1)) - 1
+			if n >= len(statuses) {
+				n = len(statuses) - 1
+			}
+			if statuses[n] != http.StatusOK {
+				w.WriteHeader(statuses[n])
+				return
+			}
+			HTTPWriteJSON(w, NewReplyData(ErrOk))
+		}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, &hits
+}
+
+func testRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Max:       3,
+		Timeout:   time.Second,
+		BaseDelay: time.Millisecond,
+		MaxDelay:  time.Millisecond}
+}
+
+func TestRetryRetryableStatus(t *testing.T) {
+	u, hits := testServer(t, http.StatusServiceUnavailable, http.StatusOK)
+	e := ClientLoadBalancer(
+		[]endpoint.Endpoint{ClientRequestEndpoint(
+			context.Background(), u, "GET", "/", HTTPDecodeResponse)},
+		ClientRetry(testRetryPolicy()))
+
+	response, err := e(context.Background(), struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := response.(ReplyData); r.Status != ErrOk {
+		t.Errorf("status %d, want ok", r.Status)
+	}
+	if n := atomic.LoadInt32(hits); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	u, hits := testServer(t, http.StatusServiceUnavailable)
+	e := ClientLoadBalancer(
+		[]endpoint.Endpoint{ClientRequestEndpoint(
+			context.Background(), u, "POST", "/", HTTPDecodeResponse)},
+		ClientRetry(testRetryPolicy()))
+
+	e(context.Background(), struct{}{})
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("%d requests of POST, want 1", n)
+	}
+}
+
+func TestRetryMax(t *testing.T) {
+	u, hits := testServer(t, http.StatusServiceUnavailable)
+	e := ClientLoadBalancer(
+		[]endpoint.Endpoint{ClientRequestEndpoint(
+			context.Background(), u, "GET", "/", HTTPDecodeResponse)},
+		ClientRetry(testRetryPolicy()))
+
+	if _, err := e(context.Background(), struct{}{}); err == nil {
+		t.Error("no error after the last attempt")
+	}
+	if n := atomic.LoadInt32(hits); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	var calls int32
+	e := ClientLoadBalancer(
+		[]endpoint.Endpoint{func(context.Context, interface{}) (
+			interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, ErrRateLimited
+		}},
+		ClientRetry(testRetryPolicy()))
+
+	if _, err := e(context.Background(), nil); err != ErrRateLimited {
+		t.Errorf("error %v, want %v", err, ErrRateLimited)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	u, hits := testServer(t, http.StatusServiceUnavailable)
+	p := testRetryPolicy()
+	p.Budget = NewRetryBudget(0.5, 0)
+	e := ClientLoadBalancer(
+		[]endpoint.Endpoint{ClientRequestEndpoint(
+			context.Background(), u, "GET", "/", HTTPDecodeResponse)},
+		ClientRetry(p))
+
+	e(context.Background(), struct{}{})
+	e(context.Background(), struct{}{})
+	// a retry per two requests
+	if n := atomic.LoadInt32(hits); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	var calls int32
+	p := testRetryPolicy()
+	p.Budget = NewRetryBudget(0, 1)
+	e := ClientLoadBalancer(
+		[]endpoint.Endpoint{func(context.Context, interface{}) (
+			interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			// the transport wraps the errors of the context
+			return nil, &url.Error{
+				Op: "Get", URL: "http://svc/", Err: context.DeadlineExceeded}
+		}},
+		ClientRetry(p))
+
+	e(context.Background(), nil)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+	for _, bucket := range p.Budget.buckets {
+		if bucket.retries != 0 {
+			t.Fatal("retry budget spent on an expired request")
+		}
+	}
+}
@@ -2,7 +2,6 @@ package toolkit
 
 import (
 	"net/http"
-	"runtime"
 
 	"github.com/chuangxin1/httprouter"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -29,12 +28,6 @@ func defaultHandler(w http.ResponseWriter, r *http.Request) {
 	HTTPWriteJSON(w, RowReplyData(ver))
 }
 
-func panicHandler(w http.ResponseWriter, r *http.Request, err interface{}) {
-	e := err.(runtime.Error)
-
-	HTTPWriteJSON(w, ErrReplyData(ErrException, e.Error()))
-}
-
 func notFoundHandler(w http.ResponseWriter, req *http.Request) {
 	HTTPWriteJSON(w, ErrReplyData(ErrNotFound, `NotFound`))
 }
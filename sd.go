@@ -2,6 +2,7 @@ package toolkit
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -9,6 +10,10 @@ import (
 	consulapi "github.com/hashicorp/consul/api"
 )
 
+// consulInstancerNames service names of the instancers created by
+// NewConsulInstancer
+var consulInstancerNames sync.Map
+
 // ServiceOptions server options
 type ServiceOptions struct {
 	// Address is the address of the Consul server
@@ -112,6 +117,7 @@ func NewConsulInstancer(
 	}
 	client = consulsd.NewClient(consulClient)
 	instancer = consulsd.NewInstancer(client, logger, name, tags, passingOnly)
+	consulInstancerNames.Store(instancer, name)
 
 	return
 }
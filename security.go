@@ -0,0 +1,115 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSPNonce placeholder of the Content-Security-Policy replaced by the nonce
+// of the request, e.g. "script-src 'nonce-{nonce}'"
+const CSPNonce = `{nonce}`
+
+// SecurityHeadersConfig security headers config, empty fields are not sent
+type SecurityHeadersConfig struct {
+	// HSTSMaxAge Strict-Transport-Security max-age, only sent over HTTPS
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains adds includeSubDomains to HSTS
+	HSTSIncludeSubdomains bool
+	// HSTSPreload adds preload to HSTS
+	HSTSPreload bool
+	// NoSniff sends X-Content-Type-Options: nosniff
+	NoSniff bool
+	// FrameOptions X-Frame-Options, DENY or SAMEORIGIN
+	FrameOptions string
+	// ReferrerPolicy Referrer-Policy
+	ReferrerPolicy string
+	// PermissionsPolicy Permissions-Policy
+	PermissionsPolicy string
+	// ContentSecurityPolicy Content-Security-Policy, CSPNonce is replaced by
+	// a new nonce per request
+	ContentSecurityPolicy string
+	// CSPReportOnly sends Content-Security-Policy-Report-Only instead
+	CSPReportOnly bool
+}
+
+// DefaultSecurityHeadersConfig recommended security headers of an API
+func DefaultSecurityHeadersConfig() SecurityHeadersConfig {
+	return SecurityHeadersConfig{
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		NoSniff:               true,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+		PermissionsPolicy:     "camera=(), microphone=(), geolocation=()",
+		ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'"}
+}
+
+// ContextCSPNonce returns the Content-Security-Policy nonce of the request
+func ContextCSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(ContextKeyCSPNonce).(string)
+	return nonce
+}
+
+// isHTTPS reports whether the request reached the service over TLS
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil ||
+		strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// SecurityHeadersMiddleware add the security headers of cfg to responses
+func SecurityHeadersMiddleware(cfg SecurityHeadersConfig) HTTPMiddleware {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(durationSeconds(cfg.HSTSMaxAge))
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	cspHeader := "Content-Security-Policy"
+	if cfg.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	withNonce := strings.Contains(cfg.ContentSecurityPolicy, CSPNonce)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if hsts != "" && isHTTPS(r) {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			if cfg.NoSniff {
+				h.Set("X-Content-Type-Options", "nosniff")
+			}
+			if cfg.FrameOptions != "" {
+				h.Set("X-Frame-Options", cfg.FrameOptions)
+			}
+			if cfg.ReferrerPolicy != "" {
+				h.Set("Referrer-Policy", cfg.ReferrerPolicy)
+			}
+			if cfg.PermissionsPolicy != "" {
+				h.Set("Permissions-Policy", cfg.PermissionsPolicy)
+			}
+			csp := cfg.ContentSecurityPolicy
+			if withNonce {
+				b := make([]byte, 16)
+				rand.Read(b)
+				nonce := base64.StdEncoding.EncodeToString(b)
+				csp = strings.Replace(csp, CSPNonce, nonce, -1)
+				r = r.WithContext(
+					context.WithValue(r.Context(), ContextKeyCSPNonce, nonce))
+			}
+			if csp != "" {
+				h.Set(cspHeader, csp)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package toolkit
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	h := SecurityHeadersMiddleware(DefaultSecurityHeadersConfig())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(r *http.Request) http.Header {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Header()
+	}
+
+	header := serve(httptest.NewRequest("GET", "/", nil))
+	for k, want := range map[string]string{
+		"Strict-Transport-Security": "",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Content-Security-Policy": "default-src 'none'; " +
+			"frame-ancestors 'none'",
+	} {
+		if got := header.Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+
+	hsts := "max-age=31536000; includeSubDomains"
+	r := httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	if got := serve(r).Get("Strict-Transport-Security"); got != hsts {
+		t.Errorf("HSTS over TLS %q, want %q", got, hsts)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Proto", "HTTPS")
+	if got := serve(r).Get("Strict-Transport-Security"); got != hsts {
+		t.Errorf("HSTS behind a TLS proxy %q, want %q", got, hsts)
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	var nonces []string
+	h := SecurityHeadersMiddleware(SecurityHeadersConfig{
+		ContentSecurityPolicy: "script-src 'nonce-" + CSPNonce + "'",
+		CSPReportOnly:         true})(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			nonces = append(nonces, ContextCSPNonce(r.Context()))
+		}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		csp := w.Header().Get("Content-Security-Policy-Report-Only")
+		nonce := nonces[i]
+		if len(nonce) != 24 || csp != "script-src 'nonce-"+nonce+"'" ||
+			strings.Contains(csp, CSPNonce) {
+			t.Errorf("policy %q of nonce %q", csp, nonce)
+		}
+		if w.Header().Get("Content-Security-Policy") != "" {
+			t.Error("enforced policy sent in report only mode")
+		}
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("the nonce of two requests is the same")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/chuangxin1/httprouter"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	httptransport "github.com/go-kit/kit/transport/http"
@@ -32,9 +33,14 @@ type DecodeResponseFunc func(
 func HTTPTansportServerOptions(
 	logger log.Logger) []httptransport.ServerOption {
 	return []httptransport.ServerOption{
-		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerErrorHandler(requestIDErrorHandler{logger}),
 		httptransport.ServerErrorEncoder(HTTPEncodeError),
+		httptransport.ServerBefore(StartServerSpan),
 		httptransport.ServerBefore(PopulateRequestContext),
+		httptransport.ServerBefore(PopulateReplyHeaders),
+		httptransport.ServerAfter(WriteReplyHeaders),
+		httptransport.ServerAfter(WriteRequestID),
+		httptransport.ServerFinalizer(FinishServerSpan),
 	}
 }
 
@@ -45,18 +51,53 @@ func NewHTTPTansportServer(
 	dec httptransport.DecodeRequestFunc,
 	enc EncodeResponseFunc,
 	logger log.Logger) *httptransport.Server {
+	return NewEndpointHanderServer(
+		EndpointHander{
+			HasAuth:  hasAuth,
+			Dec:      dec,
+			Enc:      enc,
+			Endpoint: e,
+		},
+		logger)
+}
+
+// NewEndpointHanderServer new server hander with the per route settings of h
+func NewEndpointHanderServer(
+	h EndpointHander,
+	logger log.Logger) *httptransport.Server {
+	e := RecoverMiddleware()(h.Endpoint)
 	options := HTTPTansportServerOptions(logger)
-	if hasAuth {
+	if h.HasAuth {
 		e = AuthMiddleware()(e)
 	}
+	if serviceTokenConfigured() {
+		e = ServiceAuthMiddleware()(e)
+	}
+	if h.MaxConcurrency > 0 {
+		e = BulkheadMiddleware(NewBulkhead(h.MaxConcurrency, h.QueueTimeout))(e)
+	}
+	e = TimeoutMiddleware(h.Timeout)(e)
+	e = TracingMiddleware()(e)
+	e = MetricsMiddleware()(e)
+	e = AccessLogRecordMiddleware()(e)
 	return httptransport.NewServer(
 		e,
-		dec,
-		httptransport.EncodeResponseFunc(enc),
+		h.Dec,
+		httptransport.EncodeResponseFunc(h.Enc),
 		options...,
 	)
 }
 
+// HandleEndpoints register the endpoint handers on router
+func HandleEndpoints(
+	router *httprouter.Router,
+	logger log.Logger,
+	handers ...EndpointHander) {
+	for _, h := range handers {
+		router.Handler(h.Method, h.Router, NewEndpointHanderServer(h, logger))
+	}
+}
+
 // StartServer new server and start
 func StartServer(
 	addr string,
@@ -74,7 +115,7 @@ func StartServer(
 	// Interrupt handler.
 	errc := make(chan error)
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		errc <- fmt.Errorf("%s", <-c)
 	}()
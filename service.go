@@ -2,6 +2,7 @@ package toolkit
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	httptransport "github.com/go-kit/kit/transport/http"
@@ -22,4 +23,10 @@ type EndpointHander struct {
 	Dec      httptransport.DecodeRequestFunc
 	Enc      EncodeResponseFunc
 	Endpoint endpoint.Endpoint
+	// Timeout per route timeout, zero only honors the caller deadline
+	Timeout time.Duration
+	// MaxConcurrency concurrent requests of the route, zero is unlimited
+	MaxConcurrency int
+	// QueueTimeout wait for a free slot when MaxConcurrency is reached
+	QueueTimeout time.Duration
 }
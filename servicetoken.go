@@ -0,0 +1,194 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/endpoint"
+)
+
+const (
+	// JWTServiceToken verified calling service identity
+	JWTServiceToken jwtKey = `jwt_service_token`
+
+	// HTTPHeaderServiceToken HTTP header carrying the service token
+	HTTPHeaderServiceToken = `X-Service-Token`
+
+	// default service token lifetime
+	defaultServiceTokenTTL = 60 * time.Second
+)
+
+// ServiceTokenConfig service identity config
+type ServiceTokenConfig struct {
+	// Name of the local service, the issuer of outbound tokens and the
+	// expected audience of inbound tokens.
+	Name string
+	// Key shared HMAC key of the services
+	Key string
+	// TTL lifetime of minted tokens, default 60s
+	TTL time.Duration
+}
+
+// ServiceToken service identity carried between services
+type ServiceToken struct {
+	Service  string `json:"iss"`
+	Audience string `json:"aud"`
+	Expires  int64  `json:"exp"`
+}
+
+type cachedServiceToken struct {
+	token   string
+	expires time.Time
+}
+
+var (
+	serviceTokenConfig ServiceTokenConfig
+
+	serviceTokenLock  sync.Mutex
+	serviceTokenCache = map[string]cachedServiceToken{}
+)
+
+// SetServiceTokenConfig set, the endpoint servers created afterwards
+// verify the inbound service tokens
+func SetServiceTokenConfig(cfg ServiceTokenConfig) {
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultServiceTokenTTL
+	}
+	serviceTokenLock.Lock()
+	serviceTokenConfig = cfg
+	serviceTokenCache = map[string]cachedServiceToken{}
+	serviceTokenLock.Unlock()
+}
+
+// NewServiceToken mint a token identifying the local service to audience
+func NewServiceToken(audience string) (string, error) {
+	serviceTokenLock.Lock()
+	defer serviceTokenLock.Unlock()
+
+	if serviceTokenConfig.Name == "" || serviceTokenConfig.Key == "" {
+		return "", errors.New(`Service identity not configured`)
+	}
+	now := time.Now()
+	// reuse the cached token until half of its lifetime is left
+	if c, ok := serviceTokenCache[audience]; ok &&
+		c.expires.Sub(now) > serviceTokenConfig.TTL/2 {
+		return c.token, nil
+	}
+
+	expires := now.Add(serviceTokenConfig.TTL)
+	claims := jwt.MapClaims{
+		"iss": serviceTokenConfig.Name,
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": expires.Unix()}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).
+		SignedString([]byte(serviceTokenConfig.Key))
+	if err != nil {
+		return "", err
+	}
+	serviceTokenCache[audience] = cachedServiceToken{
+		token:   token,
+		expires: expires}
+	return token, nil
+}
+
+// ParseServiceToken parse and verify service token
+func ParseServiceToken(serviceToken string) (ServiceToken, error) {
+	var tok ServiceToken
+
+	serviceTokenLock.Lock()
+	cfg := serviceTokenConfig
+	serviceTokenLock.Unlock()
+	// an empty key would accept tokens signed by anyone
+	if cfg.Name == "" || cfg.Key == "" {
+		return tok, errors.New(`Service identity not configured`)
+	}
+
+	token, err := jwt.Parse(
+		serviceToken,
+		func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.New(`Unexpected signing method`)
+			}
+			return []byte(cfg.Key), nil
+		})
+	if err != nil || !token.Valid {
+		return tok, errors.New(`Invalid service authentication information`)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if !claims.VerifyAudience(cfg.Name, true) {
+		return tok, errors.New(`Service token audience mismatch`)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return tok, errors.New(`Service authentication information expired`)
+	}
+	tok.Service, _ = claims["iss"].(string)
+	tok.Audience, _ = claims["aud"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		tok.Expires = int64(exp)
+	}
+	if tok.Service == "" {
+		return tok, errors.New(`Invalid service authentication information`)
+	}
+	return tok, nil
+}
+
+// setServiceToken mint the service token for the called service, if the
+// target and the local service identity are known
+func setServiceToken(ctx context.Context, req *http.Request) {
+	target, _ := ctx.Value(ContextKeyClientTarget).(string)
+	if target == "" {
+		return
+	}
+	if token, err := NewServiceToken(target); err == nil {
+		req.Header.Set(HTTPHeaderServiceToken, token)
+	}
+}
+
+// serviceTokenConfigured reports whether the local service identity is set
+func serviceTokenConfigured() bool {
+	serviceTokenLock.Lock()
+	defer serviceTokenLock.Unlock()
+	return serviceTokenConfig.Name != "" && serviceTokenConfig.Key != ""
+}
+
+// ServiceAuthMiddleware verify the calling service token. Requests without
+// a token pass through, the calling service is stored as JWTServiceToken
+// alongside the end user stored as JWTToken by AuthMiddleware.
+func ServiceAuthMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			token, _ := ctx.Value(ContextKeyRequestServiceToken).(string)
+			if token == "" {
+				return next(ctx, request)
+			}
+			tok, err := ParseServiceToken(token)
+			if err != nil {
+				return ErrReplyData(ErrUnAuthorized, err.Error()), nil
+			}
+			ctx = context.WithValue(ctx, JWTServiceToken, tok)
+			return next(ctx, request)
+		}
+	}
+}
+
+// RequireServiceAuth only allow requests from verified services
+func RequireServiceAuth() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			if _, ok := ctx.Value(JWTServiceToken).(ServiceToken); !ok {
+				return NewReplyData(ErrUnAuthorized), nil
+			}
+			return next(ctx, request)
+		}
+	}
+}
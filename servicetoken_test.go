@@ -0,0 +1,161 @@
+package toolkit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	consulsd "github.com/go-kit/kit/sd/consul"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestParseServiceTokenNotConfigured(t *testing.T) {
+	SetServiceTokenConfig(ServiceTokenConfig{Name: "orders", Key: "secret"})
+	token, err := NewServiceToken("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty key must not accept the tokens of anyone
+	SetServiceTokenConfig(ServiceTokenConfig{Name: "users"})
+	defer SetServiceTokenConfig(ServiceTokenConfig{})
+	if _, err := ParseServiceToken(token); err == nil {
+		t.Fatal("token accepted without a key")
+	}
+	if serviceTokenConfigured() {
+		t.Fatal("configured without a key")
+	}
+}
+
+func TestParseServiceToken(t *testing.T) {
+	defer SetServiceTokenConfig(ServiceTokenConfig{})
+
+	SetServiceTokenConfig(ServiceTokenConfig{Name: "orders", Key: "secret"})
+	toUsers, err := NewServiceToken("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	toBilling, err := NewServiceToken("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetServiceTokenConfig(ServiceTokenConfig{Name: "orders", Key: "other"})
+	forged, err := NewServiceToken("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetServiceTokenConfig(ServiceTokenConfig{Name: "users", Key: "secret"})
+	tok, err := ParseServiceToken(toUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Service != "orders" || tok.Audience != "users" {
+		t.Errorf("token %+v, want orders to users", tok)
+	}
+	if tok.Expires <= time.Now().Unix() {
+		t.Errorf("token expired at %d", tok.Expires)
+	}
+
+	for name, token := range map[string]string{
+		"audience": toBilling,
+		"key":      forged,
+		"garbage":  "not.a.token",
+		"empty":    "",
+	} {
+		if _, err := ParseServiceToken(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+// testConsulClient consul client listing one instance, the blocking
+// queries wait until stop is closed
+type testConsulClient struct {
+	consulsd.Client
+	host string
+	port int
+	stop chan struct{}
+}
+
+func (c testConsulClient) Service(
+	service, tag string,
+	passingOnly bool,
+	q *consulapi.QueryOptions) (
+	[]*consulapi.ServiceEntry, *consulapi.QueryMeta, error) {
+	if q.WaitIndex > 0 {
+		<-c.stop
+		return nil, nil, context.Canceled
+	}
+	return []*consulapi.ServiceEntry{{
+			Node:    &consulapi.Node{Address: c.host},
+			Service: &consulapi.AgentService{Address: c.host, Port: c.port},
+		}},
+		&consulapi.QueryMeta{LastIndex: 1}, nil
+}
+
+func TestFactoryLoadBalancerServiceToken(t *testing.T) {
+	defer SetServiceTokenConfig(ServiceTokenConfig{})
+	SetServiceTokenConfig(ServiceTokenConfig{Name: "orders", Key: "secret"})
+
+	var (
+		lock     sync.Mutex
+		received string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			received = r.Header.Get(HTTPHeaderServiceToken)
+			lock.Unlock()
+			HTTPWriteJSON(w, NewReplyData(ErrOk))
+		}))
+	defer srv.Close()
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testConsulClient{host: host, stop: make(chan struct{})}
+	client.port, _ = strconv.Atoi(port)
+	defer close(client.stop)
+
+	instancer := consulsd.NewInstancer(
+		client, log.NewNopLogger(), "users", nil, true)
+	defer instancer.Stop()
+	// as registered by NewConsulInstancer
+	consulInstancerNames.Store(instancer, "users")
+	defer consulInstancerNames.Delete(instancer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := FactoryLoadBalancer(ctx, instancer, "GET", "/", HTTPDecodeResponse,
+		log.NewNopLogger())
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = e(context.Background(), struct{}{})
+		if err != ErrNoInstances || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	token := received
+	lock.Unlock()
+	SetServiceTokenConfig(ServiceTokenConfig{Name: "users", Key: "secret"})
+	tok, err := ParseServiceToken(token)
+	if err != nil {
+		t.Fatalf("token %q: %v", token, err)
+	}
+	if tok.Service != "orders" {
+		t.Errorf("token of %q, want orders", tok.Service)
+	}
+}
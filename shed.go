@@ -0,0 +1,268 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// PriorityNormal shed when the concurrency limit is reached
+	PriorityNormal = iota
+	// PriorityCritical never shed, health checks and admin traffic
+	PriorityCritical
+	// PriorityLow shed first, at 80% of the concurrency limit
+	PriorityLow
+)
+
+// default Retry-After of shed requests
+const shedRetryAfter = time.Second
+
+var (
+	shedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "toolkit",
+			Subsystem: "server",
+			Name:      "shed_total",
+			Help:      "Requests rejected by bulkheads and the adaptive limiter.",
+		},
+		[]string{"reason"})
+	adaptiveLimitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "toolkit",
+			Subsystem: "server",
+			Name:      "concurrency_limit",
+			Help:      "Current limit of the adaptive concurrency limiters.",
+		},
+		[]string{"limiter"})
+)
+
+func init() {
+	prometheus.MustRegister(shedRequests, adaptiveLimitGauge)
+}
+
+// Bulkhead limits the concurrent requests of a route
+type Bulkhead struct {
+	sem          chan struct{}
+	queueTimeout time.Duration
+}
+
+// NewBulkhead new Bulkhead with max concurrent requests, excess requests
+// wait up to queueTimeout for a free slot
+func NewBulkhead(max int, queueTimeout time.Duration) *Bulkhead {
+	return &Bulkhead{
+		sem:          make(chan struct{}, max),
+		queueTimeout: queueTimeout}
+}
+
+func (b *Bulkhead) acquire(ctx context.Context) bool {
+	select {
+	case b.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if b.queueTimeout <= 0 {
+		return false
+	}
+	t := time.NewTimer(b.queueTimeout)
+	defer t.Stop()
+	select {
+	case b.sem <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (b *Bulkhead) release() {
+	<-b.sem
+}
+
+// BulkheadMiddleware reject requests over the concurrency limit of b
+func BulkheadMiddleware(b *Bulkhead) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (interface{}, error) {
+			if !b.acquire(ctx) {
+				shedRequests.WithLabelValues("bulkhead").Inc()
+				SetReplyHeader(
+					ctx, "Retry-After",
+					strconv.Itoa(durationSeconds(shedRetryAfter)))
+				return NewReplyData(ErrServiceUnavailable), nil
+			}
+			defer b.release()
+			return next(ctx, request)
+		}
+	}
+}
+
+// AdaptiveLimitConfig adaptive concurrency limiter config
+type AdaptiveLimitConfig struct {
+	// Name of the limiter in the metrics, default "default"
+	Name string
+	// InitialLimit starting concurrency limit, default 100
+	InitialLimit int
+	// MinLimit lower bound of the limit, default 1
+	MinLimit int
+	// MaxLimit upper bound of the limit, default 1000
+	MaxLimit int
+	// Latency requests slower than it decrease the limit, default 1s
+	Latency time.Duration
+	// Backoff multiplicative decrease of the limit, default 0.9
+	Backoff float64
+	// FailureStatuses ReplyData statuses counted as failures, default
+	// ErrException, ErrTimeout and ErrServiceUnavailable. Endpoint errors
+	// and HTTP 5xx always are.
+	FailureStatuses []int
+}
+
+// AdaptiveLimiter AIMD concurrency limiter. The limit grows by one per
+// window of fast requests and shrinks by Backoff on slow or failed ones,
+// once for all the requests started before the last decrease.
+type AdaptiveLimiter struct {
+	lock      sync.Mutex
+	cfg       AdaptiveLimitConfig
+	isFailure func(response interface{}, err error) bool
+	gauge     prometheus.Gauge
+	limit     float64
+	inflight  int
+	// decreased time of the last decrease
+	decreased time.Time
+}
+
+// NewAdaptiveLimiter new AdaptiveLimiter
+func NewAdaptiveLimiter(cfg AdaptiveLimitConfig) *AdaptiveLimiter {
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = 100
+	}
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 1000
+	}
+	if cfg.Latency <= 0 {
+		cfg.Latency = time.Second
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.9
+	}
+	if cfg.Name == "" {
+		cfg.Name = "default"
+	}
+	if cfg.FailureStatuses == nil {
+		cfg.FailureStatuses = []int{
+			ErrException, ErrTimeout, ErrServiceUnavailable}
+	}
+	gauge := adaptiveLimitGauge.WithLabelValues(cfg.Name)
+	gauge.Set(float64(cfg.InitialLimit))
+	return &AdaptiveLimiter{
+		cfg:       cfg,
+		isFailure: defaultClassifier(cfg.FailureStatuses),
+		gauge:     gauge,
+		limit:     float64(cfg.InitialLimit)}
+}
+
+func (l *AdaptiveLimiter) acquire(priority int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if priority != PriorityCritical {
+		limit := l.limit
+		if priority == PriorityLow {
+			limit *= 0.8
+		}
+		if float64(l.inflight) >= limit {
+			return false
+		}
+	}
+	l.inflight++
+	return true
+}
+
+// release the slot of a request started at begin
+func (l *AdaptiveLimiter) release(begin time.Time, failed bool) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	inflight := l.inflight
+	l.inflight--
+	if failed || now.Sub(begin) > l.cfg.Latency {
+		if begin.Before(l.decreased) {
+			// the limit already shrank for the requests of its time
+			return
+		}
+		l.decreased = now
+		l.limit *= l.cfg.Backoff
+		if l.limit < float64(l.cfg.MinLimit) {
+			l.limit = float64(l.cfg.MinLimit)
+		}
+	} else if float64(inflight*2) >= l.limit {
+		// only grow while the limit is in use
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.cfg.MaxLimit) {
+			l.limit = float64(l.cfg.MaxLimit)
+		}
+	}
+	l.gauge.Set(l.limit)
+}
+
+// RequestPriority returns PriorityCritical for the health check, metrics,
+// version and /admin routes and PriorityNormal otherwise
+func RequestPriority(r *http.Request) int {
+	switch {
+	case r.URL.Path == "/", r.URL.Path == "/health", r.URL.Path == "/metrics",
+		strings.HasPrefix(r.URL.Path, "/admin"):
+		return PriorityCritical
+	}
+	return PriorityNormal
+}
+
+// LoadShedMiddleware shed requests over the adaptive concurrency limit,
+// priority classifies the requests, default RequestPriority. The ReplyData
+// status is recorded by the endpoint servers, the failures shrink the
+// limit.
+func LoadShedMiddleware(
+	l *AdaptiveLimiter,
+	priority func(*http.Request) int) HTTPMiddleware {
+	if priority == nil {
+		priority = RequestPriority
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.acquire(priority(r)) {
+				shedRequests.WithLabelValues("adaptive").Inc()
+				w.Header().Set(
+					"Retry-After",
+					strconv.Itoa(durationSeconds(shedRetryAfter)))
+				HTTPWriteJSON(w, NewReplyData(ErrServiceUnavailable))
+				return
+			}
+			begin := time.Now()
+			sw := &statusWriter{wrapWriter: wrapWriter{w}}
+			// shared with AccessLogMiddleware
+			rec, ok := r.Context().Value(contextKeyAccessRecord).(*accessRecord)
+			if !ok {
+				rec = &accessRecord{}
+				r = r.WithContext(context.WithValue(
+					r.Context(), contextKeyAccessRecord, rec))
+			}
+			defer func() {
+				rec.lock.Lock()
+				failed := l.isFailure(rec.response, rec.err)
+				rec.lock.Unlock()
+				l.release(
+					begin, failed || sw.status >= http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
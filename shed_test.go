@@ -0,0 +1,46 @@
+package toolkit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterBurst(t *testing.T) {
+	l := NewAdaptiveLimiter(AdaptiveLimitConfig{
+		Name: "shed_test", InitialLimit: 100, Backoff: 0.5})
+
+	// a burst of concurrent failed requests
+	var started, done sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		started.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			if !l.acquire(PriorityNormal) {
+				t.Error("request rejected under the limit")
+			}
+			begin := time.Now()
+			started.Done()
+			<-start
+			l.release(begin, true)
+		}()
+	}
+	started.Wait()
+	close(start)
+	done.Wait()
+	if l.limit != 50 {
+		t.Errorf("limit %v after the burst, want 50", l.limit)
+	}
+
+	// a request started after the decrease shrinks it again
+	l.acquire(PriorityNormal)
+	l.release(time.Now(), true)
+	if l.limit != 25 {
+		t.Errorf("limit %v, want 25", l.limit)
+	}
+	if l.inflight != 0 {
+		t.Errorf("%d requests in flight, want 0", l.inflight)
+	}
+}
@@ -0,0 +1,307 @@
+package toolkit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"strings"
+
+	"github.com/chuangxin1/toolkit/binding"
+)
+
+// MIMEOctetStream default content type of the streamed bodies
+const MIMEOctetStream = "application/octet-stream"
+
+// largest JSON body of a streamed response decoded as a ReplyData
+const streamReplySize = 64 << 10
+
+// FilePart file of a multipart request, the form tag of the field is the
+// name of the part
+type FilePart struct {
+	// FileName of the part, default the field name
+	FileName string
+	// ContentType of the part, default application/octet-stream
+	ContentType string
+	// Reader content of the file, closed once sent when it is an io.Closer
+	Reader io.Reader
+}
+
+// StreamRequest raw body of ClientEncodeStreamRequest
+type StreamRequest struct {
+	Body io.Reader
+	// ContentType default application/octet-stream
+	ContentType string
+	// ContentLength of Body, zero sends it chunked unless Body has a Len
+	// method
+	ContentLength int64
+}
+
+// StreamResponse response of HTTPDecodeStreamResponse
+type StreamResponse struct {
+	Header  http.Header
+	Written int64
+}
+
+// contextKeyResponseWriter carries the writer of HTTPDecodeStreamResponse
+const contextKeyResponseWriter contextStringKey = `response_writer`
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// ClientEncodeMultipartRequest is an EncodeRequestFunc that streams the
+// request struct as multipart/form-data. The fields tagged form are the
+// parts, FilePart, *FilePart, []FilePart and io.Reader fields are files.
+// The body is written while it is sent, so the request is not retried or
+// hedged.
+func ClientEncodeMultipartRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{}) error {
+	v := reflect.ValueOf(request)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("multipart: unsupported request %T", request)
+	}
+
+	fields, err := multipartFields(v)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	clientPrepareRequest(
+		ctx, req, request, binding.MIMEMultipartPOSTForm+
+			"; boundary="+mw.Boundary())
+	req.Body = pr
+	req.ContentLength = -1
+	req.GetBody = nil
+	markStreamed(ctx)
+
+	SafeGo(ctx, func(context.Context) {
+		err := writeMultipart(mw, fields)
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	})
+	return nil
+}
+
+// multipartField part values or files of a form field
+type multipartField struct {
+	name   string
+	values []string
+	files  []FilePart
+}
+
+// multipartFields returns the form fields of struct v
+func multipartFields(v reflect.Value) ([]multipartField, error) {
+	var fields []multipartField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("form")
+		if name == "" || name == "-" || t.Field(i).PkgPath != "" {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if field.IsNil() {
+				continue
+			}
+		}
+		part := multipartField{name: name}
+		switch f := field.Interface().(type) {
+		case FilePart:
+			part.files = []FilePart{f}
+		case *FilePart:
+			part.files = []FilePart{*f}
+		case []FilePart:
+			part.files = f
+		case io.Reader:
+			part.files = []FilePart{{Reader: f}}
+		default:
+			values, err := formatValues(field)
+			if err != nil {
+				return nil, fmt.Errorf("multipart: field %s: %v", name, err)
+			}
+			part.values = values
+		}
+		fields = append(fields, part)
+	}
+	return fields, nil
+}
+
+// writeMultipart write fields to mw
+func writeMultipart(mw *multipart.Writer, fields []multipartField) error {
+	for _, field := range fields {
+		for _, value := range field.values {
+			if err := mw.WriteField(field.name, value); err != nil {
+				return err
+			}
+		}
+		for _, f := range field.files {
+			if err := writeFilePart(mw, field.name, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFilePart write the file part name to mw
+func writeFilePart(mw *multipart.Writer, name string, f FilePart) error {
+	if f.Reader == nil {
+		return nil
+	}
+	if closer, ok := f.Reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	fileName, contentType := f.FileName, f.ContentType
+	if fileName == "" {
+		fileName = name
+	}
+	if contentType == "" {
+		contentType = MIMEOctetStream
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(name), quoteEscaper.Replace(fileName)))
+	h.Set("Content-Type", contentType)
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f.Reader)
+	return err
+}
+
+// ClientEncodeStreamRequest is an EncodeRequestFunc that sends a
+// StreamRequest or an io.Reader request as the raw body, without buffering
+// it. The body is read once, so the request is not retried or hedged.
+func ClientEncodeStreamRequest(
+	ctx context.Context,
+	req *http.Request,
+	request interface{}) error {
+	var s StreamRequest
+	switch r := request.(type) {
+	case StreamRequest:
+		s = r
+	case *StreamRequest:
+		s = *r
+	case io.Reader:
+		s.Body = r
+	default:
+		return fmt.Errorf("stream: unsupported request %T", request)
+	}
+	if s.Body == nil {
+		return errors.New("stream: nil body")
+	}
+	if s.ContentType == "" {
+		s.ContentType = MIMEOctetStream
+	}
+	if s.ContentLength <= 0 {
+		s.ContentLength = -1
+		if l, ok := s.Body.(interface{ Len() int }); ok {
+			s.ContentLength = int64(l.Len())
+		}
+	}
+
+	clientPrepareRequest(ctx, req, request, s.ContentType)
+	rc, ok := s.Body.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(s.Body)
+	}
+	if s.ContentLength == 0 {
+		rc.Close()
+		rc = http.NoBody
+	}
+	req.Body = rc
+	req.ContentLength = s.ContentLength
+	req.GetBody = nil
+	markStreamed(ctx)
+	return nil
+}
+
+// ClientResponseWriter returns a context of the client call streaming the
+// response body to w with HTTPDecodeStreamResponse
+func ClientResponseWriter(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, contextKeyResponseWriter, w)
+}
+
+// HTTPDecodeStreamResponse decode client streaming the response body to the
+// writer of ClientResponseWriter and returning a StreamResponse. A JSON
+// ReplyData of non zero status is the error of the service, it is returned
+// with its ReplyError.
+func HTTPDecodeStreamResponse(
+	ctx context.Context,
+	r *http.Response) (interface{}, error) {
+	return decodeStream(r, func() (io.Writer, error) {
+		w, ok := ctx.Value(contextKeyResponseWriter).(io.Writer)
+		if !ok {
+			return nil, errors.New("stream: no response writer in context")
+		}
+		return w, nil
+	})
+}
+
+// HTTPDecodeWriterResponse returns a decode client like
+// HTTPDecodeStreamResponse streaming the response bodies to the writer
+// returned by newWriter for each response
+func HTTPDecodeWriterResponse(
+	newWriter func(context.Context, *http.Response) (io.Writer, error),
+) DecodeResponseFunc {
+	return func(
+		ctx context.Context,
+		r *http.Response) (interface{}, error) {
+		return decodeStream(r, func() (io.Writer, error) {
+			return newWriter(ctx, r)
+		})
+	}
+}
+
+// decodeStream copy the body of r to the writer returned by writer, unless
+// it is a failed ReplyData
+func decodeStream(
+	r *http.Response,
+	writer func() (io.Writer, error)) (interface{}, error) {
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return nil, fmt.Errorf("stream: http status %d", r.StatusCode)
+	}
+	body := bufio.NewReaderSize(r.Body, streamReplySize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == binding.MIMEJSON {
+		// the replies of the service are small, a larger body is data
+		head, err := body.Peek(streamReplySize)
+		if err == io.EOF {
+			var probe struct {
+				Status *int `json:"status"`
+			}
+			if json.Unmarshal(head, &probe) == nil &&
+				probe.Status != nil && *probe.Status != ErrOk {
+				var reply ReplyData
+				json.Unmarshal(head, &reply)
+				return reply, reply.Err()
+			}
+		}
+	}
+
+	w, err := writer()
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(w, body)
+	return StreamResponse{Header: r.Header, Written: n}, err
+}
@@ -0,0 +1,104 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+func testStreamResponse(contentType, body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Body:       ioutil.NopCloser(strings.NewReader(body))}
+}
+
+func TestDecodeStreamReplyError(t *testing.T) {
+	var w bytes.Buffer
+	ctx := ClientResponseWriter(context.Background(), &w)
+	r := testStreamResponse("application/json; charset=utf-8",
+		`{"status":4,"msg":"failed"}`)
+
+	response, err := HTTPDecodeStreamResponse(ctx, r)
+	if !IsReplyStatus(err, 4) {
+		t.Fatalf("error %v, want the reply status 4", err)
+	}
+	if reply, ok := response.(ReplyData); !ok || reply.Status != 4 {
+		t.Errorf("response %#v, want the ReplyData", response)
+	}
+	if w.Len() != 0 {
+		t.Errorf("%d bytes written of a failed reply", w.Len())
+	}
+}
+
+func TestDecodeStreamData(t *testing.T) {
+	large := `{"status":4,"data":"` +
+		strings.Repeat("x", streamReplySize) + `"}`
+	for _, body := range []struct{ contentType, body string }{
+		{"application/json", `{"status":0,"data":{}}`},
+		{"application/json", `[1,2,3]`},
+		{"application/json", large},
+		{MIMEOctetStream, `{"status":4}`},
+	} {
+		var w bytes.Buffer
+		ctx := ClientResponseWriter(context.Background(), &w)
+		response, err := HTTPDecodeStreamResponse(
+			ctx, testStreamResponse(body.contentType, body.body))
+		if err != nil {
+			t.Errorf("%.20s: %v", body.body, err)
+			continue
+		}
+		s := response.(StreamResponse)
+		if w.String() != body.body || s.Written != int64(len(body.body)) {
+			t.Errorf("%.20s: wrote %d bytes, want the body", body.body,
+				s.Written)
+		}
+	}
+}
+
+func TestStreamRequestNotRetried(t *testing.T) {
+	for name, call := range map[string]struct {
+		enc     httptransport.EncodeRequestFunc
+		request interface{}
+	}{
+		"stream": {ClientEncodeStreamRequest, strings.NewReader("body")},
+		"multipart": {ClientEncodeMultipartRequest, &struct {
+			File FilePart `form:"file"`
+		}{FilePart{Reader: strings.NewReader("body")}}},
+	} {
+		u, hits := testServer(t, http.StatusServiceUnavailable)
+		e := ClientLoadBalancer(
+			[]endpoint.Endpoint{ClientRequestEndpoint(
+				context.Background(), u, "PUT", "/", HTTPDecodeResponse,
+				ClientEncoder(call.enc))},
+			ClientRetry(testRetryPolicy()))
+
+		if _, err := e(context.Background(), call.request); err == nil {
+			t.Errorf("%s: no error of status 503", name)
+		}
+		if n := atomic.LoadInt32(hits); n != 1 {
+			t.Errorf("%s: %d requests, want 1", name, n)
+		}
+	}
+}
+
+func TestMultipartUnsupportedField(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	request := struct {
+		Name string         `form:"name"`
+		Meta map[string]int `form:"meta"`
+	}{"a", map[string]int{"b": 1}}
+
+	if err := ClientEncodeMultipartRequest(
+		context.Background(), req, request); err == nil {
+		t.Error("no error of the map field")
+	}
+}
@@ -1,41 +1,114 @@
 package toolkit
 
 import (
+	"encoding"
+	"fmt"
 	"net/url"
 	"reflect"
 	"strconv"
+	"time"
 )
 
-// URLValuesStruct convert struct to url.Values
+// URLValuesStruct convert struct to url.Values, the fields tagged form
+// except the path params. Nil pointers and values of unsupported types are
+// left out.
 func URLValuesStruct(obj interface{}) url.Values {
-	t := reflect.TypeOf(obj)
-	v := reflect.ValueOf(obj)
 	values := url.Values{}
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return values
+	}
+	t := v.Type()
 	for i := 0; i < t.NumField(); i++ {
-		key := t.Field(i).Tag.Get("form")
-		value := format(v.Field(i), v.Field(i).Interface())
-
-		values.Add(key, value)
+		f := t.Field(i)
+		key := f.Tag.Get("form")
+		if key == "" || key == "-" || f.PkgPath != "" ||
+			f.Tag.Get("path") != "" {
+			continue
+		}
+		list, err := formatValues(v.Field(i))
+		if err != nil {
+			continue
+		}
+		for _, value := range list {
+			values.Add(key, value)
+		}
 	}
 	return values
 }
 
-func format(v reflect.Value, data interface{}) string {
-	var s string
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// formatValues returns the form values of v, one per element of slices and
+// arrays, none for nil
+func formatValues(v reflect.Value) ([]string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		list := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values, err := formatValues(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, values...)
+		}
+		return list, nil
+	}
+	s, err := formatValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// formatValue returns the form value of v
+func formatValue(v reflect.Value) (string, error) {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
 	switch v.Kind() {
 	case reflect.String:
-		s = data.(string)
-	case reflect.Int:
-		s = strconv.FormatInt(int64(data.(int)), 10)
-	case reflect.Uint:
-		s = strconv.FormatUint(data.(uint64), 10)
+		return v.String(), nil
 	case reflect.Bool:
-		s = strconv.FormatBool(data.(bool))
-	case reflect.Float32:
-	case reflect.Float64:
-		s = strconv.FormatFloat(data.(float64), 'f', -1, 32)
-	default:
-		s = "" // fmt.Sprintf("unsupported kind %s", v.Type())
-	}
-	return s
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(
+			v.Float(), 'f', -1, v.Type().Bits()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return string(b), nil
+		}
+	}
+	if v.Type().Implements(stringerType) {
+		return v.Interface().(fmt.Stringer).String(), nil
+	}
+	return "", fmt.Errorf("unsupported form value type %s", v.Type())
 }
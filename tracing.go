@@ -0,0 +1,250 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/chuangxin1/httprouter"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracingExporterOTLP export spans to an OTLP/HTTP collector
+	TracingExporterOTLP = `otlp`
+	// TracingExporterFile export spans as JSON lines to a file
+	TracingExporterFile = `file`
+	// TracingExporterStdout export spans as JSON to stdout
+	TracingExporterStdout = `stdout`
+
+	tracerName = `github.com/chuangxin1/toolkit`
+)
+
+// TracingConfig tracing config
+type TracingConfig struct {
+	// ServiceName service.name resource attribute
+	ServiceName string
+	// Exporter TracingExporterOTLP, TracingExporterFile or
+	// TracingExporterStdout, empty disables span export
+	Exporter string
+	// Endpoint OTLP collector host:port, default localhost:4318
+	Endpoint string
+	// Insecure use http instead of https for OTLP
+	Insecure bool
+	// File path of the file exporter
+	File string
+	// SampleRatio ratio of sampled root spans, default 1
+	SampleRatio float64
+}
+
+var (
+	tracerProvider *sdktrace.TracerProvider
+	// tracingFile file of the file exporter, closed on shutdown
+	tracingFile io.Closer
+)
+
+func init() {
+	// propagate traceparent/tracestate even when no exporter is configured
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// SetTracingConfig set and start the span exporter
+func SetTracingConfig(cfg TracingConfig) error {
+	var (
+		exp  sdktrace.SpanExporter
+		file *os.File
+		err  error
+	)
+	switch cfg.Exporter {
+	case TracingExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err = otlptracehttp.New(context.Background(), opts...)
+	case TracingExporterFile:
+		file, err = os.OpenFile(
+			cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			exp, err = stdouttrace.New(stdouttrace.WithWriter(file))
+			if err != nil {
+				file.Close()
+			}
+		}
+	case TracingExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(
+			sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", cfg.ServiceName))))
+	otel.SetTracerProvider(tp)
+	// flush and stop the exporter replaced
+	ShutdownTracing(context.Background())
+	tracerProvider = tp
+	if file != nil {
+		tracingFile = file
+	}
+	return nil
+}
+
+// ShutdownTracing flush and stop the span exporter, and close the file of
+// the file exporter
+func ShutdownTracing(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	err := tracerProvider.Shutdown(ctx)
+	tracerProvider = nil
+	if tracingFile != nil {
+		if e := tracingFile.Close(); err == nil {
+			err = e
+		}
+		tracingFile = nil
+	}
+	return err
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// finishSpan record err and end span
+func finishSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartServerSpan is a RequestFunc that starts the server span, the parent
+// is extracted from the traceparent and tracestate headers
+func StartServerSpan(ctx context.Context, r *http.Request) context.Context {
+	ctx = otel.GetTextMapPropagator().Extract(
+		ctx, propagation.HeaderCarrier(r.Header))
+	name := httprouter.ContextRoutePath(ctx)
+	if name == "" {
+		name = r.URL.Path
+	}
+	ctx, _ = tracer().Start(
+		ctx,
+		r.Method+" "+name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", name),
+			attribute.String("http.target", r.URL.Path)))
+	return ctx
+}
+
+// FinishServerSpan is a ServerFinalizerFunc that ends the server span
+func FinishServerSpan(ctx context.Context, code int, _ *http.Request) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("http.status_code", code))
+	if code >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(code))
+	}
+	span.End()
+}
+
+// TracingMiddleware annotate the server span with the ReplyData status
+func TracingMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (response interface{}, err error) {
+			response, err = next(ctx, request)
+			span := trace.SpanFromContext(ctx)
+			status := replyStatus(response, err)
+			span.SetAttributes(attribute.String("reply.status", status))
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			} else if status != strconv.Itoa(ErrOk) {
+				span.SetStatus(codes.Error, status)
+			}
+			return
+		}
+	}
+}
+
+// clientTracingMiddleware start the client span of an outbound call
+func clientTracingMiddleware(target, route string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(
+			ctx context.Context,
+			request interface{}) (response interface{}, err error) {
+			ctx, span := tracer().Start(
+				ctx,
+				route,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("peer.service", target),
+					attribute.String("http.route", route)))
+			defer func() {
+				status := replyStatus(response, err)
+				span.SetAttributes(attribute.String("reply.status", status))
+				finishSpan(span, err)
+			}()
+			return next(ctx, request)
+		}
+	}
+}
+
+// injectTrace set the traceparent and tracestate headers of req
+func injectTrace(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(
+		ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// redisTracingProcess go-redis WrapProcess hook starting a span per command
+func redisTracingProcess(ctx context.Context) func(
+	old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+	return func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			_, span := tracer().Start(
+				ctx,
+				"redis."+cmd.Name(),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("db.system", "redis"),
+					attribute.String("db.operation", cmd.Name())))
+			err := old(cmd)
+			if err == redis.Nil {
+				span.End()
+				return err
+			}
+			finishSpan(span, err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testTracer records the spans ended while the test runs
+func testTracer(t *testing.T) *tracetest.SpanRecorder {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func testSpan(
+	t *testing.T,
+	rec *tracetest.SpanRecorder,
+	kind trace.SpanKind) sdktrace.ReadOnlySpan {
+	for _, s := range rec.Ended() {
+		if s.SpanKind() == kind {
+			return s
+		}
+	}
+	t.Fatalf("no %v span in %d spans", kind, len(rec.Ended()))
+	return nil
+}
+
+func TestTracePropagation(t *testing.T) {
+	rec := testTracer(t)
+	var traceparent string
+	downstream := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("Traceparent")
+			HTTPWriteJSON(w, NewReplyData(ErrDataNotFound))
+		}))
+	defer downstream.Close()
+	u, err := url.Parse(downstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := ClientRequestEndpoint(
+		context.Background(), u, "GET", "/users", HTTPDecodeResponse)
+	h := NewHTTPTansportServer(false,
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			return call(ctx, request)
+		},
+		func(context.Context, *http.Request) (interface{}, error) {
+			return struct{}{}, nil
+		},
+		HTTPWriteCtxJSON, log.NewNopLogger())
+
+	r := httptest.NewRequest("GET", "/users/1", nil)
+	r.Header.Set("Traceparent",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	server := testSpan(t, rec, trace.SpanKindServer)
+	client := testSpan(t, rec, trace.SpanKindClient)
+	if server.Name() != "GET /users/1" ||
+		server.Parent().SpanID().String() != "b7ad6b7169203331" ||
+		server.SpanContext().TraceID().String() !=
+			"0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("server span %s of parent %v, want the traceparent",
+			server.Name(), server.Parent())
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("the client span is not a child of the server span")
+	}
+	want := "00-0af7651916cd43dd8448eb211c80319c-" +
+		client.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("downstream traceparent %q, want %q", traceparent, want)
+	}
+	// the failed ReplyData fails the server span, the client span notes it
+	if server.Status().Code != codes.Error {
+		t.Errorf("server span status %v, want an error", server.Status())
+	}
+	status := ""
+	for _, a := range client.Attributes() {
+		if a.Key == "reply.status" {
+			status = a.Value.AsString()
+		}
+	}
+	if status != "1007" {
+		t.Errorf("client span reply.status %q, want 1007", status)
+	}
+}
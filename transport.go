@@ -0,0 +1,206 @@
+package toolkit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// TransportConfig HTTP transport of the client endpoints
+type TransportConfig struct {
+	// MaxIdleConns idle connections of all the hosts, default 100
+	MaxIdleConns int
+	// MaxIdleConnsPerHost idle connections kept per host, default 32
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost connections per host, zero is unlimited
+	MaxConnsPerHost int
+	// IdleConnTimeout time an idle connection is kept, default 90s
+	IdleConnTimeout time.Duration
+	// DialTimeout connect timeout, default 30s
+	DialTimeout time.Duration
+	// KeepAlive TCP keep alive period, default 30s
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout default 10s
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout time waiting for the response headers, zero is
+	// no timeout
+	ResponseHeaderTimeout time.Duration
+	// Timeout of the whole request, zero is no timeout
+	Timeout time.Duration
+	// CAFile PEM certificates verifying the servers, default system roots
+	CAFile string
+	// CertFile and KeyFile PEM client certificate of mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName verified in the server certificates, default the host
+	ServerName string
+	// InsecureSkipVerify disables the verification of the servers
+	InsecureSkipVerify bool
+	// DisableHTTP2 disables HTTP/2 over TLS
+	DisableHTTP2 bool
+	// Proxy URL of the proxy, default the HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY environment
+	Proxy string
+	// Scheme of the instances found by service discovery, http or https,
+	// default http
+	Scheme string
+}
+
+// transportClient http.Client and instance scheme of a service
+type transportClient struct {
+	client *http.Client
+	scheme string
+}
+
+var (
+	transportLock    sync.RWMutex
+	transportClients = map[string]transportClient{}
+)
+
+// NewHTTPClient new http.Client of cfg, it may be shared by the client
+// endpoints with ClientHTTPClient
+func NewHTTPClient(cfg TransportConfig) (*http.Client, error) {
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = 100
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = 32
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = 90 * time.Second
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 30 * time.Second
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = 30 * time.Second
+	}
+	if cfg.TLSHandshakeTimeout <= 0 {
+		cfg.TLSHandshakeTimeout = 10 * time.Second
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate in " + cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.KeepAlive}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     !cfg.DisableHTTP2,
+	}
+	if cfg.DisableHTTP2 {
+		// a non nil empty map turns HTTP/2 off
+		transport.TLSNextProto = map[string]func(
+			string, *tls.Conn) http.RoundTripper{}
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// SetTransportConfig set the HTTP transport of the endpoints calling
+// service, the name given with ClientTarget, the discovered service or the
+// host of the instance. An empty service sets the default transport. The
+// endpoints of a service share the connections of its transport, it
+// applies to the endpoints already created. The idle connections of the
+// replaced transport are closed, the requests in flight finish on it.
+func SetTransportConfig(service string, cfg TransportConfig) error {
+	switch cfg.Scheme {
+	case "", "http", "https":
+	default:
+		return errors.New("unsupported scheme " + cfg.Scheme)
+	}
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		return err
+	}
+	transportLock.Lock()
+	old := transportClients[service]
+	transportClients[service] = transportClient{client, cfg.Scheme}
+	transportLock.Unlock()
+	if old.client != nil {
+		old.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// ClientHTTPClient sets the http.Client of the client endpoints, it takes
+// precedence over SetTransportConfig
+func ClientHTTPClient(client *http.Client) ClientOption {
+	return func(o *clientOptions) { o.client = client }
+}
+
+// clientTransport returns the transport of service, the default one when
+// it has none
+func clientTransport(service string) transportClient {
+	transportLock.RLock()
+	defer transportLock.RUnlock()
+	if t, ok := transportClients[service]; ok {
+		return t
+	}
+	return transportClients[""]
+}
+
+// clientDoer sends the requests of the endpoints calling service with its
+// current transport
+type clientDoer struct {
+	service string
+	o       *clientOptions
+}
+
+func (d clientDoer) Do(req *http.Request) (*http.Response, error) {
+	t := clientTransport(d.service)
+	if d.o.discovered && t.scheme != "" && req.URL.Scheme != t.scheme {
+		u := *req.URL
+		u.Scheme = t.scheme
+		req.URL = &u
+	}
+	if d.o.client != nil {
+		return d.o.client.Do(req)
+	}
+	if t.client == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return t.client.Do(req)
+}
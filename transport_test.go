@@ -0,0 +1,49 @@
+package toolkit
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetTransportConfigClosesIdle(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	t.Cleanup(func() {
+		transportLock.Lock()
+		delete(transportClients, "transport-test")
+		transportLock.Unlock()
+	})
+
+	if err := SetTransportConfig(
+		"transport-test", TransportConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := clientTransport("transport-test").client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if err := SetTransportConfig(
+		"transport-test", TransportConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("the idle connection of the old transport is open")
+	}
+}